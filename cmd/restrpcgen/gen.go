@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+
+type service struct {
+	name   string
+	routes []*route
+}
+
+type generator struct {
+	pkg      *pkgInfo
+	services []*service
+	types    *typeSet
+	buf      bytes.Buffer
+}
+
+func newGenerator(pkg *pkgInfo, services []*service) *generator {
+
+	g := &generator{pkg: pkg, services: services, types: newTypeSet(pkg)}
+	for _, svc := range services {
+		for _, r := range svc.routes {
+			if r.args != nil {
+				g.types.add(r.args, r.file)
+			}
+			if r.ret != nil {
+				g.types.add(r.ret, r.file)
+			}
+		}
+	}
+	return g
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+const generatedBy = "Code generated by restrpcgen. DO NOT EDIT."
+
+// paramName returns the name of the func parameter of a path parameter.
+func paramName(name string) string {
+
+	switch name {
+	case "c", "ctx", "args", "body", "ret", "err", "urlPath":
+		return name + "Arg"
+	}
+	if token.Lookup(name).IsKeyword() {
+		return name + "Arg"
+	}
+	return name
+}
+
+// patternString returns the pattern of a route, eg. "POST /v1/foo/{arg1}/bar".
+func patternString(r *route) string {
+
+	var b strings.Builder
+	b.WriteString(r.verb + " ")
+	for _, seg := range r.path {
+		b.WriteByte('/')
+		if seg.param == "" {
+			b.WriteString(seg.static)
+		} else if seg.catchAll {
+			b.WriteString("{" + seg.param + "...}")
+		} else {
+			b.WriteString("{" + seg.param + "}")
+		}
+	}
+	return b.String()
+}
+
+// ---------------------------------------------------------------------------
+
+// genGo generates a Go client depending on the standard library only.
+func (g *generator) genGo(pkgName string) ([]byte, error) {
+
+	g.printf("// %s\n\npackage %s\n\nimport (\n", generatedBy, pkgName)
+	imports := map[string]bool{}
+	for _, path := range []string{"bytes", "context", "encoding/json", "fmt", "io", "io/ioutil", "net/http", "net/url", "strings"} {
+		imports[path] = true
+		g.printf("\t%q\n", path)
+	}
+	paths, named := g.types.sortedImports()
+	if len(paths) > 0 {
+		g.printf("\n")
+	}
+	for _, path := range paths {
+		if imports[path] {
+			continue
+		}
+		if name, ok := named[path]; ok {
+			g.printf("\t%s %q\n", name, path)
+		} else {
+			g.printf("\t%q\n", path)
+		}
+	}
+	g.printf(")\n\n")
+
+	for _, name := range g.types.names {
+		td := g.pkg.types[name]
+		if td.spec.Assign.IsValid() {
+			g.printf("type %s = %s\n\n", exported(name), g.types.goType(td.spec.Type))
+		} else {
+			g.printf("type %s %s\n\n", exported(name), g.types.goType(td.spec.Type))
+		}
+	}
+
+	for _, svc := range g.services {
+		client := exported(svc.name) + "Client"
+		g.printf("// %s is a client of %s.\n", client, svc.name)
+		g.printf("type %s struct {\n", client)
+		g.printf("\tBaseURL string // eg. \"http://foo.com\"\n")
+		g.printf("\tHeader http.Header // headers added to every request\n")
+		g.printf("\tClient *http.Client // http.DefaultClient if nil\n")
+		g.printf("}\n\n")
+		for _, r := range svc.routes {
+			g.genGoMethod(client, r)
+		}
+	}
+
+	g.buf.WriteString(strings.Replace(goHelpers, "'", "`", -1))
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) genGoMethod(client string, r *route) {
+
+	params := []string{"ctx context.Context"}
+	path := `strings.TrimSuffix(c.BaseURL, "/")`
+	static := ""
+	for _, seg := range r.path {
+		if seg.param == "" {
+			static += "/" + seg.static
+			continue
+		}
+		name := paramName(seg.param)
+		params = append(params, name+" string")
+		path += " + " + strconv.Quote(static+"/")
+		if seg.catchAll {
+			path += " + escapeTail(" + name + ")"
+		} else {
+			path += " + url.PathEscape(" + name + ")"
+		}
+		static = ""
+	}
+	if static != "" {
+		path += " + " + strconv.Quote(static)
+	}
+	if r.args != nil {
+		params = append(params, "args "+g.types.goType(r.args))
+	}
+
+	results, ret := "(err error)", "nil"
+	if r.ret != nil {
+		results, ret = "(ret "+g.types.goType(r.ret)+", err error)", "&ret"
+	}
+
+	kind, body, reqBody := "noBody", "nil", ""
+	switch r.body {
+	case bodyQuery:
+		kind, body = "queryBody", "args"
+	case bodyJSON:
+		kind, body = "jsonBody", "args"
+	case bodyReqJSON:
+		kind, reqBody = "jsonBody", "args.ReqBody"
+	case bodyRaw:
+		kind, reqBody = "rawBody", "args.ReqBody"
+	case bodyRawBytes:
+		kind, reqBody = "rawBody", "bytes.NewReader(args.ReqBody)"
+	}
+
+	g.printf("// %s calls %s.\n", r.name, patternString(r))
+	g.printf("func (c *%s) %s(%s) %s {\n", client, r.name, strings.Join(params, ", "), results)
+	g.printf("\turlPath := %s\n", path)
+	if reqBody != "" {
+		body = "body"
+		if _, ok := r.args.(*ast.StarExpr); ok { // args may be nil
+			g.printf("\tvar body interface{}\n\tif args != nil {\n\t\tbody = %s\n\t}\n", reqBody)
+		} else {
+			g.printf("\tbody := %s\n", reqBody)
+		}
+	}
+	g.printf("\terr = call(ctx, c.Client, c.Header, %q, urlPath, %s, %s, %s)\n", r.verb, kind, body, ret)
+	g.printf("\treturn\n}\n\n")
+}
+
+const goHelpers = `// Error is the error reply of a service, the same as httputil.Error writes.
+// RFC 7807 problem details (application/problem+json) are decoded as well.
+type Error struct {
+	Code   int    'json:"-"'
+	Err    string 'json:"error"'
+	Errno  int    'json:"errno,omitempty"'
+	Type   string 'json:"type,omitempty"'
+	Detail string 'json:"detail,omitempty"'
+}
+
+func (e *Error) Error() string {
+	if e.Err == "" {
+		return http.StatusText(e.Code)
+	}
+	return e.Err
+}
+
+const (
+	noBody = iota
+	queryBody
+	jsonBody
+	rawBody
+)
+
+func call(ctx context.Context, client *http.Client, header http.Header, method, u string, kind int, args, ret interface{}) error {
+	var body io.Reader
+	var ctype string
+	switch kind {
+	case queryBody:
+		query, err := encodeQuery(args)
+		if err != nil {
+			return err
+		}
+		if query != "" {
+			u += "?" + query
+		}
+	case jsonBody:
+		b, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		body, ctype = bytes.NewReader(b), "application/json"
+	case rawBody:
+		if r, ok := args.(io.Reader); ok { // args is nil if there is no body
+			body = r
+		}
+		ctype = "application/octet-stream"
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		e := &Error{Code: resp.StatusCode}
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(b, e) != nil {
+			e.Err = strings.TrimSpace(string(b))
+		} else if e.Err == "" {
+			e.Err = e.Detail
+		}
+		return e
+	}
+	if ret == nil || method == "HEAD" || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(ret)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// encodeQuery encodes the JSON fields of args into a query string.
+func encodeQuery(args interface{}) (string, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err = dec.Decode(&fields); err != nil {
+		return "", err
+	}
+	query := make(url.Values)
+	for k, v := range fields {
+		switch v := v.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range v {
+				query.Add(k, fmt.Sprint(item))
+			}
+		default:
+			query.Add(k, fmt.Sprint(v))
+		}
+	}
+	return query.Encode(), nil
+}
+
+// escapeTail escapes each segment of a catch-all path parameter.
+func escapeTail(tail string) string {
+	parts := strings.Split(tail, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+`
+
+// ---------------------------------------------------------------------------
+
+// genTS generates a TypeScript client based on fetch.
+func (g *generator) genTS() []byte {
+
+	g.printf("// %s\n\n", generatedBy)
+
+	for _, name := range g.types.names {
+		td := g.pkg.types[name]
+		if st := g.pkg.structOf(td.spec.Type); st != nil && td.spec.Type == st {
+			g.printf("export interface %s {\n", exported(name))
+			for _, field := range g.types.tsFields(st) {
+				g.printf("  %s;\n", field)
+			}
+			g.printf("}\n\n")
+		} else {
+			g.printf("export type %s = %s;\n\n", exported(name), g.types.tsType(td.spec.Type))
+		}
+	}
+
+	g.buf.WriteString(tsHelpers)
+
+	for _, svc := range g.services {
+		g.printf("\nexport class %sClient {\n", exported(svc.name))
+		g.printf("  constructor(readonly baseURL: string, readonly init: RequestInit = {}) {}\n")
+		for _, r := range svc.routes {
+			g.genTSMethod(r)
+		}
+		g.printf("}\n")
+	}
+	return g.buf.Bytes()
+}
+
+func (g *generator) genTSMethod(r *route) {
+
+	var params []string
+	var path strings.Builder
+	for _, seg := range r.path {
+		path.WriteByte('/')
+		if seg.param == "" {
+			path.WriteString(seg.static)
+			continue
+		}
+		name := paramName(seg.param)
+		params = append(params, name+": string")
+		if seg.catchAll {
+			path.WriteString("${escapeTail(" + name + ")}")
+		} else {
+			path.WriteString("${encodeURIComponent(" + name + ")}")
+		}
+	}
+
+	kind, body := "NO_BODY", ""
+	if r.args != nil {
+		switch r.body {
+		case bodyQuery:
+			kind, body = "QUERY_BODY", "args"
+			params = append(params, "args: "+g.types.tsType(r.args))
+		case bodyJSON:
+			kind, body = "JSON_BODY", "args"
+			params = append(params, "args: "+g.types.tsType(r.args))
+		case bodyReqJSON:
+			kind, body = "JSON_BODY", "body"
+			if st := g.pkg.structOf(r.args); st != nil {
+				for _, field := range st.Fields.List {
+					if len(field.Names) == 1 && field.Names[0].Name == "ReqBody" {
+						params = append(params, "body: "+g.types.tsType(field.Type))
+					}
+				}
+			}
+		case bodyRaw, bodyRawBytes:
+			kind, body = "RAW_BODY", "body"
+			params = append(params, "body: BodyInit")
+		}
+	}
+
+	ret := "void"
+	if r.ret != nil {
+		ret = g.types.tsType(r.ret)
+	}
+	args := []string{"this", strconv.Quote(r.verb), "`" + path.String() + "`", kind}
+	if body != "" {
+		args = append(args, body)
+	}
+
+	g.printf("\n  /** %s */\n", patternString(r))
+	g.printf("  %s(%s): Promise<%s> {\n", r.name, strings.Join(params, ", "), ret)
+	g.printf("    return call<%s>(%s);\n", ret, strings.Join(args, ", "))
+	g.printf("  }\n")
+}
+
+const tsHelpers = `// ApiError is the error reply of a service, the same as httputil.Error writes.
+export class ApiError extends Error {
+  constructor(readonly code: number, message: string, readonly errno?: number) {
+    super(message);
+  }
+}
+
+const NO_BODY = 0;
+const QUERY_BODY = 1;
+const JSON_BODY = 2;
+const RAW_BODY = 3;
+
+interface Client {
+  baseURL: string;
+  init: RequestInit;
+}
+
+async function call<T>(c: Client, method: string, path: string, kind: number, args?: any): Promise<T> {
+  let url = c.baseURL.replace(/\/$/, "") + path;
+  const headers = new Headers(c.init.headers);
+  let body: BodyInit | undefined;
+  switch (kind) {
+    case QUERY_BODY: {
+      const query = encodeQuery(args);
+      if (query) {
+        url += "?" + query;
+      }
+      break;
+    }
+    case JSON_BODY:
+      body = JSON.stringify(args);
+      headers.set("Content-Type", "application/json");
+      break;
+    case RAW_BODY:
+      body = args;
+      headers.set("Content-Type", "application/octet-stream");
+      break;
+  }
+
+  const resp = await fetch(url, { ...c.init, method, headers, body });
+  const text = await resp.text();
+  if (!resp.ok) {
+    let message = text.trim();
+    let errno: number | undefined;
+    try {
+      const ret = JSON.parse(text);
+      if (ret && (ret.error || ret.detail)) {
+        message = ret.error || ret.detail; // {error, errno} or RFC 7807 problem details
+        errno = ret.errno;
+      }
+    } catch (e) {
+      // not a JSON error reply
+    }
+    throw new ApiError(resp.status, message || resp.statusText, errno);
+  }
+  return (text ? JSON.parse(text) : undefined) as T;
+}
+
+function encodeQuery(args: any): string {
+  const query = new URLSearchParams();
+  for (const key of Object.keys(args || {})) {
+    const value = args[key];
+    if (value === undefined || value === null) {
+      continue;
+    }
+    for (const item of Array.isArray(value) ? value : [value]) {
+      query.append(key, String(item));
+    }
+  }
+  return query.toString();
+}
+
+function escapeTail(tail: string): string {
+  return tail.split("/").map(encodeURIComponent).join("/");
+}
+`
+
+// ---------------------------------------------------------------------------
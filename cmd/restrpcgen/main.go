@@ -0,0 +1,120 @@
+/*
+Restrpcgen generates clients of services installed by restrpc.Router.Register.
+
+It reads the Go source of a package, finds the methods of the given receiver
+types that restrpc.Router.Register installs (by the same naming and signature
+conventions), and writes a client with one method per route. The Go client
+depends on the standard library only: the args and ret types are copied into
+it (with local type names exported), and error replies are decoded into its
+Error type, the same as httputil.Error writes. With -lang ts, a TypeScript
+client based on fetch is written instead.
+
+Usage:
+
+	restrpcgen -type Service [-prefix /v1] [-sep _] [-catchall] [-manual] [-lang go|ts] [-o client/client.go] [-pkg client] [dir]
+
+Typically used with go generate:
+
+	//go:generate restrpcgen -type Service -prefix /v1 -o client/client.go
+	//go:generate restrpcgen -type Service -prefix /v1 -o web/client.ts
+
+Routes installed manually (restrpc.Router.Register(rcvr, routes)) are declared
+in the doc comment of the method, which, like the routes table, may have any
+name:
+
+	//restrpc:route GET /buckets/{bucket}/objects/*
+	func (p *Service) Object(args *getObjectArgs, env *restrpc.Env) (ret objectInfo, err error)
+
+If the receiver is registered with a routes table only, -manual generates the
+declared routes only, the same as Register installs.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames     = flag.String("type", "", "comma-separated list of receiver type names; required")
+	patternPrefix = flag.String("prefix", "", "the same as restrpc.Router.PatternPrefix")
+	separator     = flag.String("sep", "", "the same as restrpc.Router.Separator")
+	catchAll      = flag.Bool("catchall", false, "the same as restrpc.Router.CatchAll")
+	manualOnly    = flag.Bool("manual", false, "generate the routes declared by //restrpc:route only")
+	lang          = flag.String("lang", "", "go or ts; default by the extension of -o")
+	output        = flag.String("o", "client/client.go", "output file")
+	pkgName       = flag.String("pkg", "", "package name of the Go client; default the base name of the output directory")
+)
+
+func usage() {
+
+	fmt.Fprintf(os.Stderr, "Usage: restrpcgen -type T [flags] [dir]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if err := run(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string) error {
+
+	pkg, err := loadPackage(dir)
+	if err != nil {
+		return err
+	}
+
+	var services []*service
+	for _, name := range strings.Split(*typeNames, ",") {
+		name = strings.TrimSpace(name)
+		routes, err := pkg.routesOf(name, *patternPrefix, *separator, *catchAll, *manualOnly)
+		if err != nil {
+			return err
+		}
+		services = append(services, &service{name: name, routes: routes})
+	}
+
+	g := newGenerator(pkg, services)
+	var src []byte
+	switch lang := *lang; {
+	case lang == "ts" || (lang == "" && strings.HasSuffix(*output, ".ts")):
+		src = g.genTS()
+	case lang == "go" || lang == "":
+		name := *pkgName
+		if name == "" {
+			abs, err := filepath.Abs(filepath.Dir(*output))
+			if err != nil {
+				return err
+			}
+			name = strings.Replace(filepath.Base(abs), "-", "_", -1)
+		}
+		if src, err = g.genGo(name); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("restrpcgen: unknown language %q", lang)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*output, src, 0644)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+
+func newTestGenerator(t *testing.T) *generator {
+
+	pkg, err := loadPackage("testdata/service")
+	if err != nil {
+		t.Fatal("loadPackage failed:", err)
+	}
+	routes, err := pkg.routesOf("Service", "/v1", "", false, false)
+	if err != nil {
+		t.Fatal("routesOf failed:", err)
+	}
+	return newGenerator(pkg, []*service{{name: "Service", routes: routes}})
+}
+
+func TestRoutes(t *testing.T) {
+
+	g := newTestGenerator(t)
+	var patterns []string
+	for _, r := range g.services[0].routes {
+		patterns = append(patterns, r.name+" "+patternString(r))
+	}
+	expected := []string{
+		"BucketStats GET /v1/buckets/{bucket}/stats",
+		"DeleteObjects_ DELETE /v1/objects/{arg1}",
+		"GetBuckets_Objects GET /v1/buckets/{arg1}/objects",
+		"GetVersion GET /v1/version",
+		"PostObjects_Meta POST /v1/objects/{arg1}/meta",
+		"PutObject PUT /v1/buckets/{bucket}/objects/{key...}",
+	}
+	if strings.Join(patterns, "\n") != strings.Join(expected, "\n") {
+		t.Fatal("routes:", patterns)
+	}
+
+	pkg, err := loadPackage("testdata/service")
+	if err != nil {
+		t.Fatal("loadPackage failed:", err)
+	}
+	routes, err := pkg.routesOf("Service", "", "", false, true)
+	if err != nil || len(routes) != 2 || routes[0].name != "BucketStats" || routes[1].name != "PutObject" {
+		t.Fatal("manual routes:", routes, err)
+	}
+}
+
+func TestGenGo(t *testing.T) {
+
+	src, err := newTestGenerator(t).genGo("client")
+	if err != nil {
+		t.Fatal("genGo failed:", err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", src, 0)
+	if err != nil {
+		t.Fatal("generated code doesn't parse:", err, "\n"+string(src))
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err = conf.Check("client", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatal("generated code doesn't type-check:", err, "\n"+string(src))
+	}
+
+	for _, s := range []string{
+		"type ObjectInfo struct",
+		"Mode     Mode      `json:\"mode,omitempty\"`",
+		"type Mode string",
+		"func (c *ServiceClient) GetBuckets_Objects(ctx context.Context, arg1 string, args *ListArgs) (ret []ObjectInfo, err error)",
+		"func (c *ServiceClient) PutObject(ctx context.Context, bucket string, key string, args *PutArgs) (ret ObjectInfo, err error)",
+		`"/v1/buckets/" + url.PathEscape(bucket) + "/objects/" + escapeTail(key)`,
+		"if args != nil {\n\t\tbody = args.ReqBody\n\t}",
+		`"PUT", urlPath, rawBody, body, &ret)`,
+		`"POST", urlPath, jsonBody, body, nil)`,
+		"func (c *ServiceClient) BucketStats(ctx context.Context, bucket string, args *ListArgs) (ret int64, err error)",
+		`"GET", urlPath, queryBody, args, &ret)`,
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Fatal("generated code doesn't contain:", s, "\n"+string(src))
+		}
+	}
+	if strings.Contains(string(src), "DoDump") || strings.Contains(string(src), "BucketEnv") {
+		t.Fatal("unexpected generated code:\n" + string(src))
+	}
+}
+
+func TestGenTS(t *testing.T) {
+
+	src := string(newTestGenerator(t).genTS())
+	for _, s := range []string{
+		"export interface ObjectInfo {\n  \"key\": string;\n  \"size\": number;\n  \"mode\"?: Mode;\n  \"modified\": string;\n  \"owner\"?: Owner;\n}",
+		"export type Mode = string;",
+		"GetBuckets_Objects(arg1: string, args: ListArgs): Promise<ObjectInfo[]>",
+		"PutObject(bucket: string, key: string, body: BodyInit): Promise<ObjectInfo>",
+		"`/v1/buckets/${encodeURIComponent(bucket)}/objects/${escapeTail(key)}`, RAW_BODY, body)",
+		"PostObjects_Meta(arg1: string, body: { [key: string]: string }): Promise<void>",
+		"DeleteObjects_(arg1: string): Promise<void>",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatal("generated code doesn't contain:", s, "\n"+src)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/http/hfac"
+	"github.com/qiniu/http/restrpc"
+)
+
+// ---------------------------------------------------------------------------
+
+// pkgInfo holds the declarations of a package needed to generate clients.
+type pkgInfo struct {
+	name    string
+	fset    *token.FileSet
+	types   map[string]*typeDecl
+	methods map[string][]*methodDecl // keyed by the receiver type name
+}
+
+type typeDecl struct {
+	spec *ast.TypeSpec
+	file *ast.File
+}
+
+type methodDecl struct {
+	decl *ast.FuncDecl
+	file *ast.File
+}
+
+func loadPackage(dir string) (*pkgInfo, error) {
+
+	fset := token.NewFileSet()
+	noTests := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}
+	pkgs, err := parser.ParseDir(fset, dir, noTests, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, errors.New("restrpcgen: expect exactly one package in " + dir)
+	}
+
+	p := &pkgInfo{
+		fset:    fset,
+		types:   make(map[string]*typeDecl),
+		methods: make(map[string][]*methodDecl),
+	}
+	for name, pkg := range pkgs {
+		p.name = name
+		fnames := make([]string, 0, len(pkg.Files))
+		for fname := range pkg.Files {
+			fnames = append(fnames, fname)
+		}
+		sort.Strings(fnames)
+		for _, fname := range fnames {
+			p.addFile(pkg.Files[fname])
+		}
+	}
+	return p, nil
+}
+
+func (p *pkgInfo) addFile(f *ast.File) {
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok {
+					p.types[ts.Name.Name] = &typeDecl{ts, f}
+				}
+			}
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) != 1 {
+				continue
+			}
+			rtype := d.Recv.List[0].Type
+			if star, ok := rtype.(*ast.StarExpr); ok {
+				rtype = star.X
+			}
+			if ident, ok := rtype.(*ast.Ident); ok {
+				p.methods[ident.Name] = append(p.methods[ident.Name], &methodDecl{d, f})
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// segment is a segment of a route path.
+type segment struct {
+	static   string // static segment if param is empty
+	param    string // name of a path parameter
+	catchAll bool
+}
+
+type bodyKind int
+
+const (
+	bodyNone     bodyKind = iota
+	bodyQuery             // args encoded as a query string
+	bodyJSON              // args encoded as a JSON body
+	bodyRaw               // args.ReqBody sent as is
+	bodyRawBytes          // args.ReqBody ([]byte) sent as is
+	bodyReqJSON           // args.ReqBody encoded as a JSON body
+)
+
+// route is a route of a service method.
+type route struct {
+	name string // name of the method
+	verb string // eg. "POST"
+	path []segment
+	args ast.Expr // nil if the method takes no args
+	ret  ast.Expr // nil if the method returns no ret
+	body bodyKind
+	file *ast.File
+}
+
+var methodPrefixes = map[string]string{
+	"Get": "GET", "Post": "POST", "Put": "PUT", "Delete": "DELETE",
+	"Patch": "PATCH", "Head": "HEAD", "Options": "OPTIONS",
+}
+
+// routesOf returns the routes restrpc.Router.Register installs for methods of
+// typeName. A `//restrpc:route PATTERN` line in the doc comment of a method
+// gives the pattern of a manually installed route; like a routes table
+// passed to Register, it may be on a method of any name. If manualOnly, the
+// receiver is registered with a routes table only, and methods without the
+// directive are skipped.
+func (p *pkgInfo) routesOf(typeName string, patternPrefix, sep string, catchAll, manualOnly bool) (routes []*route, err error) {
+
+	if _, ok := p.types[typeName]; !ok {
+		return nil, errors.New("restrpcgen: type not found: " + typeName)
+	}
+	for _, m := range p.methods[typeName] {
+		name := m.decl.Name.Name
+		if !ast.IsExported(name) {
+			continue
+		}
+		var pattern restrpc.Pattern
+		if manual := routeDirective(m.decl.Doc); manual != "" {
+			pattern = restrpc.NewPattern(manual)
+			if patternPrefix != "" {
+				pattern = append(pattern[:1], append(restrpc.Pattern{strings.TrimPrefix(patternPrefix, "/")}, pattern[1:]...)...)
+			}
+		} else {
+			if manualOnly {
+				continue
+			}
+			prefix, ok := hfac.PrefixOf(name)
+			if !ok {
+				continue
+			}
+			if _, ok = methodPrefixes[prefix]; !ok {
+				continue
+			}
+			pattern, _ = restrpc.MethodPatternEx(name, patternPrefix, sep, catchAll)
+		}
+		r, ok := p.routeOf(m, strings.ToUpper(pattern[0]))
+		if !ok {
+			continue
+		}
+		r.path = segmentsOf(pattern)
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].name < routes[j].name })
+	return
+}
+
+func routeDirective(doc *ast.CommentGroup) string {
+
+	if doc == nil {
+		return ""
+	}
+	for _, c := range doc.List {
+		if strings.HasPrefix(c.Text, "//restrpc:route ") {
+			return strings.TrimSpace(c.Text[len("//restrpc:route "):])
+		}
+	}
+	return ""
+}
+
+func segmentsOf(pattern restrpc.Pattern) (segs []segment) {
+
+	nargs := 0
+	for _, seg := range pattern[1:] {
+		if seg != "*" && seg != "**" && !(strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")) {
+			segs = append(segs, segment{static: strings.ToLower(seg)})
+			continue
+		}
+		nargs++
+		s := segment{catchAll: seg == "**" || strings.HasSuffix(seg, "...}")}
+		if strings.HasPrefix(seg, "{") {
+			s.param = strings.TrimSuffix(seg[1:len(seg)-1], "...")
+			if pos := strings.Index(s.param, ":"); pos >= 0 {
+				s.param = s.param[:pos]
+			}
+		} else {
+			s.param = "arg" + strconv.Itoa(nargs)
+		}
+		segs = append(segs, s)
+	}
+	return
+}
+
+// routeOf checks the signature of a method, the same as rpcutil.HandlerCreator:
+//
+//	(rcvr *XXXX) YYYY([ctx context.Context, ][args ZZZZ, ][env ENV]) ([ret RRRR, ]err error)
+func (p *pkgInfo) routeOf(m *methodDecl, verb string) (r *route, ok bool) {
+
+	ft := m.decl.Type
+	params := flatten(ft.Params)
+	results := flatten(ft.Results)
+
+	if n := len(params); n > 0 && p.isEnv(params[n-1], m.file) {
+		params = params[:n-1]
+	}
+	if len(params) > 0 && isSelector(params[0], "context", "Context") {
+		params = params[1:]
+	}
+	if len(params) > 1 || len(results) > 2 {
+		return
+	}
+	if n := len(results); n > 0 {
+		if ident, ok := results[n-1].(*ast.Ident); !ok || ident.Name != "error" {
+			return nil, false
+		}
+	}
+
+	r = &route{name: m.decl.Name.Name, verb: verb, file: m.file}
+	if len(results) == 2 {
+		r.ret = results[0]
+	}
+	if len(params) == 1 {
+		r.args = params[0]
+		r.body = p.bodyOf(r.args, verb)
+	}
+	return r, true
+}
+
+func (p *pkgInfo) bodyOf(args ast.Expr, verb string) bodyKind {
+
+	if st := p.structOf(args); st != nil {
+		for _, f := range st.Fields.List {
+			if len(f.Names) != 1 || f.Names[0].Name != "ReqBody" {
+				continue
+			}
+			switch {
+			case isBytes(f.Type):
+				return bodyRawBytes
+			case isSelector(f.Type, "io", "Reader"), isSelector(f.Type, "io", "ReadCloser"):
+				return bodyRaw
+			}
+			return bodyReqJSON
+		}
+	}
+	switch verb {
+	case "GET", "DELETE", "HEAD", "OPTIONS":
+		return bodyQuery
+	}
+	return bodyJSON
+}
+
+// structOf returns the struct type of expr (or a pointer to it), or nil.
+func (p *pkgInfo) structOf(expr ast.Expr) *ast.StructType {
+
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		if td, ok := p.types[ident.Name]; ok {
+			expr = td.spec.Type
+		}
+	}
+	st, _ := expr.(*ast.StructType)
+	return st
+}
+
+// isEnv reports whether expr is an env type: restrpc.Env (or a pointer to it),
+// or a pointer to a local type with an OpenEnv method or embedding an env.
+func (p *pkgInfo) isEnv(expr ast.Expr, f *ast.File) bool {
+
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *ast.SelectorExpr:
+		return t.Sel.Name == "Env"
+	case *ast.Ident:
+		for _, m := range p.methods[t.Name] {
+			if m.decl.Name.Name == "OpenEnv" {
+				return true
+			}
+		}
+		if st := p.structOf(t); st != nil {
+			for _, field := range st.Fields.List {
+				if len(field.Names) == 0 && p.isEnv(field.Type, f) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func flatten(fl *ast.FieldList) (types []ast.Expr) {
+
+	if fl == nil {
+		return
+	}
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, f.Type)
+		}
+	}
+	return
+}
+
+func isSelector(expr ast.Expr, pkg, name string) bool {
+
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == pkg
+}
+
+func isBytes(expr ast.Expr) bool {
+
+	at, ok := expr.(*ast.ArrayType)
+	if !ok || at.Len != nil {
+		return false
+	}
+	ident, ok := at.Elt.(*ast.Ident)
+	return ok && (ident.Name == "byte" || ident.Name == "uint8")
+}
+
+// jsonName returns the JSON name of a struct field, and whether it is omitted
+// if empty. An empty name means the field is not encoded.
+func jsonName(f *ast.Field, name string) (string, bool) {
+
+	if f.Tag == nil {
+		return name, false
+	}
+	tag, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return name, false
+	}
+	parts := strings.Split(reflect.StructTag(tag).Get("json"), ",")
+	if parts[0] == "-" && len(parts) == 1 {
+		return "", false
+	}
+	if parts[0] != "" {
+		name = parts[0]
+	}
+	omitempty := false
+	for _, opt := range parts[1:] {
+		omitempty = omitempty || opt == "omitempty"
+	}
+	return name, omitempty
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/qiniu/http/restrpc"
+)
+
+type Service struct{}
+
+type bucketEnv struct {
+	restrpc.Env
+}
+
+type mode string
+
+type objectInfo struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Mode     mode      `json:"mode,omitempty"`
+	Modified time.Time `json:"modified"`
+	Owner    *owner    `json:"owner"`
+	secret   string
+}
+
+type owner struct {
+	Name string `json:"name"`
+}
+
+type listArgs struct {
+	Bucket string   `path:"bucket"`
+	Marker string   `json:"marker"`
+	Limit  int      `json:"limit"`
+	Tags   []string `json:"tags"`
+}
+
+type putArgs struct {
+	ReqBody io.Reader
+}
+
+type metaArgs struct {
+	ReqBody map[string]string
+}
+
+func (p *Service) GetBuckets_Objects(ctx context.Context, args *listArgs, env *bucketEnv) (ret []objectInfo, err error) {
+	return
+}
+
+//restrpc:route PUT /buckets/{bucket}/objects/{key...}
+func (p *Service) PutObject(args *putArgs, env *restrpc.Env) (ret objectInfo, err error) {
+	return
+}
+
+func (p *Service) PostObjects_Meta(args *metaArgs, env *restrpc.Env) error {
+	return nil
+}
+
+func (p *Service) DeleteObjects_(env *restrpc.Env) error {
+	return nil
+}
+
+func (p *Service) DoDump(w http.ResponseWriter, req *http.Request) {
+}
+
+func (p *Service) GetVersion() (ret string, err error) {
+	return
+}
+
+//restrpc:route GET /buckets/{bucket}/stats
+func (p *Service) BucketStats(args *listArgs, env *restrpc.Env) (ret int64, err error) {
+	return
+}
+
+func (p *Service) Close() error { // not a route
+	return nil
+}
+
+func (p *Service) internal() {
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"go/ast"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ---------------------------------------------------------------------------
+
+// typeSet collects the local types (recursively) and imports referenced by
+// args and ret types, so they can be copied into a generated client.
+type typeSet struct {
+	pkg     *pkgInfo
+	names   []string // local types in the order found
+	seen    map[string]bool
+	imports map[string]string // package name => import path
+}
+
+func newTypeSet(pkg *pkgInfo) *typeSet {
+
+	return &typeSet{pkg: pkg, seen: make(map[string]bool), imports: make(map[string]string)}
+}
+
+func (s *typeSet) add(expr ast.Expr, f *ast.File) {
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		td, ok := s.pkg.types[t.Name]
+		if !ok || s.seen[t.Name] {
+			return
+		}
+		s.seen[t.Name] = true
+		s.names = append(s.names, t.Name)
+		s.add(td.spec.Type, td.file)
+	case *ast.StarExpr:
+		s.add(t.X, f)
+	case *ast.ArrayType:
+		s.add(t.Elt, f)
+	case *ast.MapType:
+		s.add(t.Key, f)
+		s.add(t.Value, f)
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			s.add(field.Type, f)
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			if path := importOf(f, x.Name); path != "" {
+				s.imports[x.Name] = path
+			}
+		}
+	}
+}
+
+// sortedImports returns the import paths, keyed by package names not equal to
+// the base of the path.
+func (s *typeSet) sortedImports() (paths []string, named map[string]string) {
+
+	named = make(map[string]string)
+	for name, path := range s.imports {
+		paths = append(paths, path)
+		if path[strings.LastIndex(path, "/")+1:] != name {
+			named[path] = name
+		}
+	}
+	sort.Strings(paths)
+	return
+}
+
+// importOf returns the path of the package imported as name by f.
+func importOf(f *ast.File, name string) string {
+
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return path
+			}
+			continue
+		}
+		if path[strings.LastIndex(path, "/")+1:] == name {
+			return path
+		}
+	}
+	return ""
+}
+
+// exported returns the exported form of a local type name, eg. "fooBarArgs"
+// => "FooBarArgs".
+func exported(name string) string {
+
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
+
+// ---------------------------------------------------------------------------
+
+// goType returns the Go source of a type expression, with local types exported.
+func (s *typeSet) goType(expr ast.Expr) string {
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := s.pkg.types[t.Name]; ok {
+			return exported(t.Name)
+		}
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + s.goType(t.X)
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + s.goType(t.Elt)
+		}
+		if lit, ok := t.Len.(*ast.BasicLit); ok {
+			return "[" + lit.Value + "]" + s.goType(t.Elt)
+		}
+		return "[]" + s.goType(t.Elt)
+	case *ast.MapType:
+		return "map[" + s.goType(t.Key) + "]" + s.goType(t.Value)
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			return x.Name + "." + t.Sel.Name
+		}
+	case *ast.StructType:
+		var b strings.Builder
+		b.WriteString("struct {\n")
+		for _, field := range t.Fields.List {
+			names := make([]string, len(field.Names))
+			for i, name := range field.Names {
+				names[i] = name.Name
+			}
+			if len(names) > 0 {
+				b.WriteString(strings.Join(names, ", ") + " ")
+			}
+			b.WriteString(s.goType(field.Type))
+			if field.Tag != nil {
+				b.WriteString(" " + field.Tag.Value)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return "interface{}"
+}
+
+// tsType returns the TypeScript type of a type expression.
+func (s *typeSet) tsType(expr ast.Expr) string {
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := s.pkg.types[t.Name]; ok {
+			return exported(t.Name)
+		}
+		switch t.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return "boolean"
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+			"uintptr", "float32", "float64", "byte", "rune":
+			return "number"
+		}
+		return "any"
+	case *ast.StarExpr:
+		return s.tsType(t.X)
+	case *ast.ArrayType:
+		if isBytes(t) {
+			return "string"
+		}
+		return s.tsType(t.Elt) + "[]"
+	case *ast.MapType:
+		return "{ [key: string]: " + s.tsType(t.Value) + " }"
+	case *ast.SelectorExpr:
+		if isSelector(t, "time", "Time") {
+			return "string"
+		}
+		return "any"
+	case *ast.StructType:
+		return "{ " + strings.Join(s.tsFields(t), "; ") + " }"
+	}
+	return "any"
+}
+
+// tsFields returns the TypeScript fields of a struct, eg. "a?: string".
+func (s *typeSet) tsFields(st *ast.StructType) (fields []string) {
+
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 { // embedded
+			if tag, _ := jsonName(field, ""); tag == "" {
+				if embedded := s.pkg.structOf(field.Type); embedded != nil {
+					fields = append(fields, s.tsFields(embedded)...)
+				}
+				continue
+			}
+		}
+		_, isPtr := field.Type.(*ast.StarExpr)
+		for _, name := range field.Names {
+			if !ast.IsExported(name.Name) {
+				continue
+			}
+			jname, omitempty := jsonName(field, name.Name)
+			if jname == "" {
+				continue
+			}
+			key := strconv.Quote(jname)
+			if omitempty || isPtr {
+				key += "?"
+			}
+			fields = append(fields, key+": "+s.tsType(field.Type))
+		}
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------
@@ -28,7 +28,8 @@ func Encode(v interface{}) (ret []byte, err error) {
 	return EncodeValue(reflect.ValueOf(v), "json")
 }
 
-// EncodeValue encodes a value into ``URL encoded'' form
+// EncodeValue encodes a value into ``URL encoded'' form. Fields of a struct
+// without a cate tag (eg. only a `path` tag), or tagged "-", are skipped.
 func EncodeValue(v reflect.Value, cate string) (ret []byte, err error) {
 
 retry:
@@ -53,10 +54,11 @@ func encodeStructValue(v reflect.Value, cate string) (ret []byte, err error) {
 	n := vt.NumField()
 	for i := 0; i < n; i++ {
 		sf := vt.Field(i)
-		if sf.Tag == "" { // no tag, skip
+		tag1 := sf.Tag.Get(cate)
+		if tag1 == "" || tag1 == "-" { // no tag of cate, skip
 			continue
 		}
-		tag, opts, err2 := parseEncodeTag(sf.Tag.Get(cate))
+		tag, opts, err2 := parseEncodeTag(tag1)
 		if err2 != nil {
 			return nil, err2
 		}
@@ -19,10 +19,18 @@ type foo struct {
 	D bool     `json:"d"`
 }
 
+type bar struct {
+	Key  string `path:"key"`
+	A    int    `json:"a"`
+	Body []byte `json:"-"`
+	B    int
+}
+
 func TestEncode(t *testing.T) {
 
 	cases := []encodeTestCase{
 		{&foo{A: 1}, "a=1&d=false", nil},
+		{&bar{Key: "k", A: 1, Body: []byte("x"), B: 2}, "a=1", nil}, // fields without a json tag, or tagged "-", are skipped
 		{&foo{A: 0, D: true}, "d=true", nil},
 		{&foo{A: 0, B: []int{1, 3, 2}, D: true}, "b=1&b=3&b=2&d=true", nil},
 		{&foo{A: 0, C: []string{"1 3", "a4", "b2"}, D: true}, "c=1+3&c=a4&c=b2&d=true", nil},
@@ -0,0 +1,421 @@
+package formutil
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+)
+
+/* --------------------------------------------------------------------
+
+Validate 按 `validate` 标签检查结构体的各个字段。样例：
+
+	type Args struct {
+		Bucket string   `json:"bucket" validate:"required,min=3,max=63,regexp=^[a-z0-9-]+$"`
+		Mode   string   `json:"mode" validate:"omitempty,oneof=public private"`
+		Email  string   `json:"email" validate:"omitempty,email"`
+		Tags   []string `json:"tags" validate:"max=10,dive,min=1"`
+		Owner  *User    `json:"owner" validate:"required"`
+	}
+
+支持的规则：
+
+	required	字段不能为零值
+	omitempty	字段为零值时跳过其余规则
+	min=N, max=N	数值的大小，或字符串(按字符计)、slice、map 的长度
+	len=N		字符串(按字符计)、slice、map 的长度
+	oneof=A B C	字段的值必须是其中之一
+	email		邮件地址
+	url		带 scheme 和 host 的绝对 URL
+	regexp=RE	字符串必须匹配 RE。RE 可以包含逗号，因此必须是最后一个规则
+	dive		其后的规则作用于 slice、map 的每一个元素
+
+结构体类型(或其指针)的字段总是被递归检查。字段在错误中按 cate 标签命名，没有 cate 标签时按 `path`
+标签(restrpc 的路径参数)命名。没有这两种标签的 ReqBody 字段(请求的 body，参见 restrpc)如果是结构体
+(或其指针)，它的字段与外层的字段一样检查，即不加 "ReqBody." 前缀；否则(例如 slice、map，或 nil 指针)
+它本身按 `validate` 标签检查，在错误中称为 body：
+
+	type Args struct {
+		Bucket  string `path:"bucket" validate:"min=3"`
+		ReqBody []Item `validate:"min=1,dive"`
+	}
+
+规则在第一次使用时解析并缓存。CheckTags 预先检查一个类型的所有 `validate` 标签，例如在注册
+handler 时调用，使错误的标签在启动时而不是请求时被发现。
+
+// ------------------------------------------------------------------*/
+
+// FieldError describes a field failing validation.
+type FieldError struct {
+	Field string // wire name of the field, eg. "owner.name" or "tags[1]"
+	Rule  string // the failed rule, eg. "min=1"
+}
+
+func (e *FieldError) Error() string {
+
+	return e.Field + ": " + e.Rule
+}
+
+// ValidationError lists every field failing validation.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "invalid arguments: " + strings.Join(msgs, "; ")
+}
+
+// TagError describes a malformed `validate` tag.
+type TagError struct {
+	Type  reflect.Type // the struct type
+	Field string       // name of the field, eg. "Bucket"
+	Err   error
+}
+
+func (e *TagError) Error() string {
+
+	return "formutil: invalid validate tag of " + e.Type.String() + "." + e.Field + ": " + e.Err.Error()
+}
+
+// CheckTags parses the `validate` tags of a struct type (or a pointer to
+// struct), and of the types of its fields, and caches the rules for
+// validating values of them. It returns a *TagError if a tag is malformed.
+func CheckTags(t reflect.Type) error {
+
+	return checkTags(t, make(map[reflect.Type]bool))
+}
+
+func checkTags(t reflect.Type, checked map[reflect.Type]bool) error {
+
+	for k := t.Kind(); k == reflect.Ptr || k == reflect.Slice || k == reflect.Array || k == reflect.Map; k = t.Kind() {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || checked[t] {
+		return nil
+	}
+	checked[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous { // unexported
+			continue
+		}
+		if _, err := parseRules(sf.Tag.Get("validate")); err != nil {
+			return &TagError{Type: t, Field: sf.Name, Err: err}
+		}
+		if err := checkTags(sf.Type, checked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates a struct (or a pointer to struct) by its `validate`
+// tags. Fields are named by their cate tags (eg. "json") in errors.
+func Validate(v interface{}, cate string) error {
+
+	return ValidateValue(reflect.ValueOf(v), cate)
+}
+
+// ValidateValue validates a struct value by its `validate` tags.
+func ValidateValue(v reflect.Value, cate string) error {
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return syscall.EINVAL
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return syscall.EINVAL
+	}
+
+	var errs ValidationError
+	if err := validateStruct(&errs, v, "", cate); err != nil {
+		return err
+	}
+	if len(errs.Fields) > 0 {
+		return &errs
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------
+
+func validateStruct(errs *ValidationError, v reflect.Value, prefix string, cate string) (err error) {
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous { // unexported
+			continue
+		}
+		name := sf.Name
+		tag := sf.Tag.Get(cate)
+		if tag == "" && cate != "path" {
+			tag = sf.Tag.Get("path") // path parameters of a restrpc request
+		}
+		if tag != "" {
+			if pos := strings.Index(tag, ","); pos >= 0 {
+				tag = tag[:pos]
+			}
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		} else if sf.Anonymous || sf.Name == "ReqBody" { // ReqBody: the body of a restrpc request
+			sfv := v.Field(i)
+			if sfv.Kind() == reflect.Ptr && !sfv.IsNil() {
+				sfv = sfv.Elem()
+			}
+			if sfv.Kind() == reflect.Struct {
+				if err = validateStruct(errs, sfv, prefix, cate); err != nil {
+					return
+				}
+				continue
+			}
+			if sf.Anonymous {
+				continue
+			}
+			name = "body" // a nil pointer, or not a struct
+		}
+		rules, err2 := parseRules(sf.Tag.Get("validate"))
+		if err2 != nil {
+			return err2
+		}
+		if err = validateField(errs, v.Field(i), prefix+name, rules, cate); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func validateField(errs *ValidationError, v reflect.Value, name string, rules []rule, cate string) (err error) {
+
+	for i, r := range rules {
+		switch r.name {
+		case "omitempty":
+			if isZero(v) {
+				return
+			}
+			continue
+		case "dive":
+			return validateElems(errs, v, name, rules[i+1:], cate)
+		}
+		if !r.check(v) {
+			errs.Fields = append(errs.Fields, &FieldError{Field: name, Rule: r.String()})
+			return
+		}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		return validateStruct(errs, v, name+".", cate)
+	}
+	return
+}
+
+func validateElems(errs *ValidationError, v reflect.Value, name string, rules []rule, cate string) (err error) {
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			err = validateField(errs, v.Index(i), name+"["+strconv.Itoa(i)+"]", rules, cate)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			err = validateField(errs, v.MapIndex(key), name+"["+toString(key)+"]", rules, cate)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// --------------------------------------------------------------------
+
+type rule struct {
+	name  string
+	param string
+	num   float64
+	re    *regexp.Regexp
+}
+
+func (r *rule) String() string {
+
+	if r.param == "" {
+		return r.name
+	}
+	return r.name + "=" + r.param
+}
+
+var ruleCache sync.Map // map[string][]rule
+
+func parseRules(tag string) (rules []rule, err error) {
+
+	if tag == "" {
+		return
+	}
+	if v, ok := ruleCache.Load(tag); ok {
+		return v.([]rule), nil
+	}
+
+	for tag1 := tag; tag1 != ""; {
+		var item string
+		if strings.HasPrefix(tag1, "regexp=") {
+			item, tag1 = tag1, ""
+		} else if pos := strings.Index(tag1, ","); pos >= 0 {
+			item, tag1 = tag1[:pos], tag1[pos+1:]
+		} else {
+			item, tag1 = tag1, ""
+		}
+		r := rule{name: item}
+		if pos := strings.Index(item, "="); pos >= 0 {
+			r.name, r.param = item[:pos], item[pos+1:]
+		}
+		switch r.name {
+		case "required", "omitempty", "dive", "email", "url":
+			if r.param != "" {
+				return nil, errors.New("validate rule `" + r.name + "` takes no parameter")
+			}
+		case "min", "max", "len":
+			if r.num, err = strconv.ParseFloat(r.param, 64); err != nil {
+				return nil, errors.New("invalid validate rule: " + item)
+			}
+		case "oneof":
+		case "regexp":
+			if r.re, err = regexp.Compile(r.param); err != nil {
+				return nil, errors.New("invalid validate rule: " + item)
+			}
+		default:
+			return nil, errors.New("unknown validate rule: " + item)
+		}
+		rules = append(rules, r)
+	}
+	ruleCache.Store(tag, rules)
+	return
+}
+
+func (r *rule) check(v reflect.Value) bool {
+
+	if r.name == "required" {
+		return !isZero(v)
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return true // only `required` checks nil values
+		}
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "min":
+		n, ok := sizeOf(v)
+		return !ok || n >= r.num
+	case "max":
+		n, ok := sizeOf(v)
+		return !ok || n <= r.num
+	case "len":
+		n, ok := lenOf(v)
+		return !ok || n == r.num
+	case "oneof":
+		s := toString(v)
+		for _, opt := range strings.Fields(r.param) {
+			if s == opt {
+				return true
+			}
+		}
+		return false
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		return err == nil && addr.Address == v.String()
+	case "url":
+		u, err := url.ParseRequestURI(v.String())
+		return err == nil && u.Scheme != "" && u.Host != ""
+	case "regexp":
+		return r.re.MatchString(v.String())
+	}
+	return true
+}
+
+func sizeOf(v reflect.Value) (n float64, ok bool) {
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return lenOf(v)
+}
+
+func lenOf(v reflect.Value) (n float64, ok bool) {
+
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	}
+	return
+}
+
+func toString(v reflect.Value) string {
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return ""
+}
+
+func isZero(v reflect.Value) bool {
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// --------------------------------------------------------------------
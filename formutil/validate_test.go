@@ -0,0 +1,141 @@
+package formutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+// --------------------------------------------------------------------
+
+type User struct {
+	Name  string `json:"name" validate:"required"`
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+type Embedded struct {
+	Site string `json:"site" validate:"omitempty,url"`
+}
+
+type Args struct {
+	Embedded
+	Bucket string            `json:"bucket" validate:"required,min=3,max=8,regexp=^[a-z0-9,-]+$"`
+	Mode   string            `json:"mode,omitempty" validate:"omitempty,oneof=public private"`
+	Count  int               `json:"count" validate:"min=1,max=100"`
+	Code   string            `json:"code" validate:"len=4"`
+	Tags   []string          `json:"tags" validate:"max=2,dive,min=1"`
+	Owner  *User             `json:"owner" validate:"required"`
+	Users  []User            `json:"users" validate:"dive"`
+	Meta   map[string]string `json:"meta" validate:"dive,max=3"`
+	Hidden string            `json:"-" validate:"required"`
+}
+
+func TestValidate(t *testing.T) {
+
+	ok := Args{
+		Embedded: Embedded{Site: "http://foo.com/"},
+		Bucket:   "a,b-1",
+		Mode:     "public",
+		Count:    1,
+		Code:     "中文编码",
+		Tags:     []string{"x"},
+		Owner:    &User{Name: "foo", Email: "foo@bar.com"},
+		Meta:     map[string]string{"k": "v"},
+	}
+	if err := Validate(&ok, "json"); err != nil {
+		t.Fatal("Validate failed:", err)
+	}
+
+	bad := Args{
+		Embedded: Embedded{Site: "/foo"},
+		Bucket:   "AB",
+		Mode:     "secret",
+		Code:     "abc",
+		Tags:     []string{"x", "", "z"},
+		Users:    []User{{Name: "a"}, {Email: "bad"}},
+		Meta:     map[string]string{"k": "long"},
+	}
+	err := Validate(&bad, "json")
+	if err == nil {
+		t.Fatal("Validate ok?")
+	}
+	const msg = "invalid arguments: site: url; bucket: min=3; mode: oneof=public private; count: min=1; " +
+		"code: len=4; tags: max=2; owner: required; users[1].name: required; users[1].email: email; meta[k]: max=3"
+	if err.Error() != msg {
+		t.Fatal("unexpected error:", err)
+	}
+
+	bad = ok
+	bad.Tags = []string{"x", ""}
+	bad.Owner = &User{}
+	err = Validate(&bad, "json")
+	if err == nil || err.Error() != "invalid arguments: tags[1]: min=1; owner.name: required" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+type badItem struct {
+	Name string `json:"name" validate:"max=x"`
+}
+
+type badArgs struct {
+	A     int        `json:"a" validate:"min=1"`
+	Items []*badItem `json:"items" validate:"dive"`
+}
+
+func TestCheckTags(t *testing.T) {
+
+	if err := CheckTags(reflect.TypeOf(new(Args))); err != nil {
+		t.Fatal("CheckTags failed:", err)
+	}
+	err := CheckTags(reflect.TypeOf(new(badArgs)))
+	if e, ok := err.(*TagError); !ok || e.Type != reflect.TypeOf(badItem{}) || e.Field != "Name" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestValidateReqBody(t *testing.T) {
+
+	args := struct {
+		Bucket  string `path:"bucket" validate:"min=3"`
+		ReqBody *User
+	}{"b", &User{}}
+	err := Validate(&args, "json")
+	if err == nil || err.Error() != "invalid arguments: bucket: min=3; name: required" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestValidateReqBodyRules(t *testing.T) {
+
+	type itemsArgs struct {
+		ReqBody []User `validate:"min=1,dive"`
+	}
+	err := Validate(&itemsArgs{}, "json")
+	if err == nil || err.Error() != "invalid arguments: body: min=1" {
+		t.Fatal("unexpected error:", err)
+	}
+	err = Validate(&itemsArgs{[]User{{Name: "a"}, {}}}, "json")
+	if err == nil || err.Error() != "invalid arguments: body[1].name: required" {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var userArgs struct {
+		ReqBody *User `validate:"required"`
+	}
+	err = Validate(&userArgs, "json")
+	if err == nil || err.Error() != "invalid arguments: body: required" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestValidateBadRule(t *testing.T) {
+
+	var args struct {
+		A int `json:"a" validate:"min=x"`
+	}
+	if err := Validate(&args, "json"); err == nil || err.Error() != "invalid validate rule: min=x" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+// --------------------------------------------------------------------
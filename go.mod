@@ -1,6 +1,6 @@
 module github.com/qiniu/http
 
-go 1.12
+go 1.13
 
 require (
 	github.com/qiniu/httptest v1.0.3
@@ -5,9 +5,11 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"runtime/debug"
 	"syscall"
 
 	"github.com/qiniu/http/hfac/ctype"
+	"github.com/qiniu/http/httputil"
 )
 
 /* ---------------------------------------------------------------------------
@@ -16,13 +18,39 @@ func (rcvr *XXXX) DoYYYY(w http.ResponseWriter, req *http.Request)
 
 // -------------------------------------------------------------------------*/
 
+// Options are the options of the handlers of DoYYYY and WsYYYY methods.
+type Options struct {
+	// OnPanic, if not nil, is called after a panic of a method is recovered
+	// and logged. A 500 error is replied by calling httputil.Error, or the
+	// response is aborted if it has started, see httputil.OnPanic.
+	OnPanic func(req *http.Request, info *httputil.PanicInfo)
+}
+
 type handler struct {
-	rcvr   reflect.Value
-	method reflect.Value
+	rcvr    reflect.Value
+	method  reflect.Value
+	name    string
+	onPanic func(req *http.Request, info *httputil.PanicInfo)
+}
+
+func (h *handler) recover(w http.ResponseWriter, req *http.Request) {
+
+	if v := recover(); v != nil {
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		info := &httputil.PanicInfo{
+			Method: h.name, Route: httputil.RouteOf(req), Value: v, Stack: debug.Stack(),
+		}
+		httputil.OnPanic(w, req, info, httputil.Error, h.onPanic)
+	}
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
+	w = httputil.WithLanguage(w, req.Header.Get("Accept-Language")) // see httputil.Localize
+	defer h.recover(w, req)
+
 	w1 := reflect.ValueOf(w)
 	req1 := reflect.ValueOf(req)
 	h.method.Call([]reflect.Value{h.rcvr, w1, req1})
@@ -38,8 +66,15 @@ var unusedRequest *http.Request
 var typeOfHttpResponseWriter = reflect.TypeOf(unusedResponseWriter).Elem()
 var typeOfHttpRequest = reflect.TypeOf(unusedRequest)
 
+// NewHandler creates the handler of a DoYYYY method with the default Options.
 func NewHandler(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
 
+	return Options{}.NewHandler(rcvr, method)
+}
+
+// NewHandler creates the handler of a DoYYYY method.
+func (p Options) NewHandler(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+
 	mtype := method.Type
 
 	// Method spec:
@@ -61,7 +96,7 @@ func NewHandler(rcvr reflect.Value, method reflect.Method) (http.Handler, error)
 		return nil, syscall.EINVAL
 	}
 
-	return &handler{rcvr, method.Func}, nil
+	return &handler{rcvr, method.Func, method.Name, p.OnPanic}, nil
 }
 
 // ---------------------------------------------------------------------------
@@ -89,7 +124,7 @@ func (r HandlerFactory) Union(r2 HandlerFactory) HandlerFactory {
 
 func (r HandlerFactory) Create(rcvr reflect.Value, method reflect.Method) (string, http.Handler, error) {
 
-	prefix, ok := prefixOf(method.Name)
+	prefix, ok := PrefixOf(method.Name)
 	if !ok {
 		return "", nil, ErrMethodPrefix
 	}
@@ -106,7 +141,8 @@ func (r HandlerFactory) Create(rcvr reflect.Value, method reflect.Method) (strin
 	return "", nil, ErrMethodPrefix
 }
 
-func prefixOf(name string) (prefix string, ok bool) {
+// PrefixOf returns the prefix of a method name, eg. "Post" for "PostFoo_Bar".
+func PrefixOf(name string) (prefix string, ok bool) {
 
 	if !ctype.Is(ctype.UPPER, rune(name[0])) {
 		return
@@ -121,6 +157,7 @@ func prefixOf(name string) (prefix string, ok bool) {
 
 // Factory is a HandlerFactory.
 var Factory = HandlerFactory{
+	{Prefix: "Ws", Creator: NewWsHandler},
 	{Prefix: "Do", Creator: NewHandler},
 }
 
@@ -0,0 +1,81 @@
+package hfac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/qiniu/http/httputil"
+)
+
+// ---------------------------------------------------------------------------
+
+type panicService struct{}
+
+func (p *panicService) DoPanic(w http.ResponseWriter, req *http.Request) {
+	panic("boom")
+}
+
+func TestHandlerPanic(t *testing.T) {
+
+	var info *httputil.PanicInfo
+	factory := HandlerFactory{
+		{Prefix: "Do", Creator: Options{OnPanic: func(req *http.Request, pi *httputil.PanicInfo) { info = pi }}.NewHandler},
+	}
+
+	rcvr := reflect.ValueOf(new(panicService))
+	method, _ := rcvr.Type().MethodByName("DoPanic")
+	_, h, err := factory.Create(rcvr, method)
+	if err != nil {
+		t.Fatal("HandlerFactory.Create failed:", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != 500 || w.Body.String() != `{"error":"internal server error"}` {
+		t.Fatal("unexpected resp:", w.Code, w.Body.String())
+	}
+	if info == nil || info.Method != "DoPanic" || info.Value != "boom" {
+		t.Fatal("unexpected panic info:", info)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+var errNoBucket = httputil.NewCatalog("en").Register(&httputil.CatalogEntry{
+	Errno: 612, Code: 404, Name: "BucketNotFound",
+	Messages: map[string]string{"en": "bucket %s not found", "zh-CN": "存储空间 %s 不存在"},
+})
+
+type bucketService struct{}
+
+func (p *bucketService) DoBucket(w http.ResponseWriter, req *http.Request) {
+	httputil.Error(w, errNoBucket.New("b1"))
+}
+
+func TestHandlerLocalize(t *testing.T) {
+
+	rcvr := reflect.ValueOf(new(bucketService))
+	method, _ := rcvr.Type().MethodByName("DoBucket")
+	_, h, err := Factory.Create(rcvr, method)
+	if err != nil {
+		t.Fatal("Factory.Create failed:", err)
+	}
+
+	cases := [][2]string{
+		{"", `{"error":"bucket b1 not found","errno":612}`},
+		{"zh-CN,en;q=0.5", `{"error":"存储空间 b1 不存在","errno":612}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/bucket", nil)
+		req.Header.Set("Accept-Language", c[0])
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != 404 || w.Body.String() != c[1] {
+			t.Fatal("unexpected resp:", c[0], w.Code, w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
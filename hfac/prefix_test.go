@@ -17,14 +17,14 @@ func TestPrefix(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		prefix, ok := prefixOf(c[0])
+		prefix, ok := PrefixOf(c[0])
 		if !ok {
 			if c[1] != "" {
-				t.Fatal("prefixOf bad case:", c[0], c[1])
+				t.Fatal("PrefixOf bad case:", c[0], c[1])
 			}
 		} else {
 			if c[1] != prefix {
-				t.Fatal("prefixOf bad case:", c[0], c[1])
+				t.Fatal("PrefixOf bad case:", c[0], c[1])
 			}
 		}
 	}
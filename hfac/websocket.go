@@ -0,0 +1,288 @@
+package hfac
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/websocket"
+)
+
+/* ---------------------------------------------------------------------------
+
+func (rcvr *XXXX) WsYYYY([ctx context.Context, ]conn *websocket.Conn[, env ENV])[ (err error)]
+func (rcvr *XXXX) WsYYYY([ctx context.Context, ]in <-chan IN, out chan<- OUT[, env ENV])[ (err error)]
+
+Ws 前缀的方法处理 WebSocket 连接，restrpc.Router.Register 按 GET 请求安装它的路由，例如
+WsJobs_Events 对应 GET /jobs/<JobId>/events。所以同一个服务对象不能同时有 GetJobs_Events 与
+WsJobs_Events，Register 会报告冲突而失败。restrpc.Factory 与 Factory 都包含 Ws 前缀。
+
+- ENV 是实现了 OpenEnv/CloseEnv 的指针类型(如 *restrpc.Env)，OpenEnv 在升级连接之前调用，
+  所以可以在 OpenEnv 中鉴权，失败时以普通的 http 错误回复。
+- ctx 在方法返回、连接断开(包括心跳超时)或对方关闭连接时取消。
+- 第一种形式直接读写连接。第二种形式中，每个收到的消息按 JSON 解码为 IN 后发送到 in，发送到
+  out 的值编码为 JSON 消息；对方关闭连接后 in 被关闭。
+- 方法返回后连接被关闭：返回 nil 时的 close code 为 1000；返回错误时，4xx 错误为 1008，其他
+  错误为 1011，原因为错误信息(超过 123 字节时按 UTF-8 边界截断)。
+
+WsUpgrader 升级连接，WsPingInterval 是心跳(ping)的间隔。
+
+// -------------------------------------------------------------------------*/
+
+// WsUpgrader upgrades the connections of WsYYYY methods.
+var WsUpgrader = &websocket.Upgrader{}
+
+// WsPingInterval is the interval of pings of WsYYYY connections, see
+// websocket.Conn.KeepAlive. No ping is sent if it is 0.
+var WsPingInterval = 30 * time.Second
+
+type wsEnv interface {
+	OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error
+	CloseEnv()
+}
+
+type wsEnvContext interface {
+	Context() context.Context
+}
+
+var unusedContext *context.Context
+var unusedError *error
+var unusedWsEnv *wsEnv
+var unusedWsConn *websocket.Conn
+
+var typeOfContext = reflect.TypeOf(unusedContext).Elem()
+var typeOfError = reflect.TypeOf(unusedError).Elem()
+var typeOfWsEnv = reflect.TypeOf(unusedWsEnv).Elem()
+var typeOfWsConn = reflect.TypeOf(unusedWsConn)
+
+type wsHandler struct {
+	rcvr    reflect.Value
+	method  reflect.Value
+	name    string
+	hasCtx  bool
+	inType  reflect.Type // type of IN; nil if the method takes *websocket.Conn
+	outType reflect.Type // type of OUT
+	envType reflect.Type // ENV; nil if the method has no env argument
+	hasErr  bool
+	onPanic func(req *http.Request, info *httputil.PanicInfo)
+}
+
+// NewWsHandler creates the handler of a WsYYYY method with the default Options.
+func NewWsHandler(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+
+	return Options{}.NewWsHandler(rcvr, method)
+}
+
+// NewWsHandler creates the handler of a WsYYYY method.
+func (p Options) NewWsHandler(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+
+	mtype := method.Type
+	h := &wsHandler{rcvr: rcvr, method: method.Func, name: method.Name, onPanic: p.OnPanic}
+
+	i, n := 1, mtype.NumIn()
+	if i < n && mtype.In(i) == typeOfContext {
+		h.hasCtx = true
+		i++
+	}
+	switch {
+	case i < n && mtype.In(i) == typeOfWsConn:
+		i++
+	case i+1 < n && mtype.In(i).Kind() == reflect.Chan && mtype.In(i).ChanDir() == reflect.RecvDir &&
+		mtype.In(i+1).Kind() == reflect.Chan && mtype.In(i+1).ChanDir() == reflect.SendDir:
+		h.inType, h.outType = mtype.In(i).Elem(), mtype.In(i+1).Elem()
+		i += 2
+	default:
+		log.Println("method", method.Name, "must take a *websocket.Conn or (<-chan IN, chan<- OUT)")
+		return nil, syscall.EINVAL
+	}
+	if i < n {
+		if t := mtype.In(i); t.Kind() == reflect.Ptr && t.Implements(typeOfWsEnv) {
+			h.envType = t
+			i++
+		}
+	}
+	if i != n {
+		log.Println("method", method.Name, "has wrong arguments:", mtype)
+		return nil, syscall.EINVAL
+	}
+
+	switch {
+	case mtype.NumOut() == 0:
+	case mtype.NumOut() == 1 && mtype.Out(0) == typeOfError:
+		h.hasErr = true
+	default:
+		log.Println("method", method.Name, "must return nothing or an error:", mtype)
+		return nil, syscall.EINVAL
+	}
+	return h, nil
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	var conn *websocket.Conn
+	w = httputil.WithLanguage(w, req.Header.Get("Accept-Language")) // see httputil.Localize
+	defer func() {
+		if v := recover(); v != nil {
+			info := &httputil.PanicInfo{
+				Method: h.name, Route: httputil.RouteOf(req), Value: v, Stack: debug.Stack(),
+			}
+			if conn == nil {
+				httputil.OnPanic(w, req, info, httputil.Error, h.onPanic)
+				return
+			}
+			log.Printf("panic: %v [method: %s, route: %s]\n%s", v, info.Method, info.Route, info.Stack)
+			if h.onPanic != nil {
+				h.onPanic(req, info)
+			}
+			conn.CloseWithCode(websocket.CloseInternalServerError, "internal server error")
+		}
+	}()
+
+	ctx := req.Context()
+	var env reflect.Value
+	if h.envType != nil {
+		env = reflect.New(h.envType.Elem())
+		env1 := env.Interface().(wsEnv)
+		if err := env1.OpenEnv(h.rcvr.Interface(), &w, req); err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		defer env1.CloseEnv()
+		if envCtx, ok := env1.(wsEnvContext); ok {
+			if ctx1 := envCtx.Context(); ctx1 != nil {
+				ctx = ctx1
+			}
+		}
+	}
+
+	conn, err := WsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	// the request Context isn't canceled if a hijacked connection is broken
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn.KeepAlive(ctx, WsPingInterval)
+
+	args := []reflect.Value{h.rcvr}
+	if h.hasCtx {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+
+	var out []reflect.Value
+	if h.inType == nil {
+		go func() {
+			select {
+			case <-conn.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		args = append(args, reflect.ValueOf(conn))
+		if env.IsValid() {
+			args = append(args, env)
+		}
+		out = h.method.Call(args)
+	} else {
+		in := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, h.inType), 0)
+		outc := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, h.outType), 0)
+		go h.readLoop(ctx, cancel, conn, in)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.writeLoop(conn, outc, stop, cancel)
+		}()
+		args = append(args, in, outc)
+		if env.IsValid() {
+			args = append(args, env)
+		}
+		out = h.method.Call(args)
+		close(stop)
+		wg.Wait()
+	}
+
+	if h.hasErr {
+		if err, _ := out[0].Interface().(error); err != nil {
+			code, _, msg := httputil.GetErrorInfo(httputil.Localize(err, req))
+			conn.CloseWithCode(closeCodeOf(code), msg)
+			return
+		}
+	}
+	conn.Close()
+}
+
+// closeCodeOf maps the http status code of an error to a close code.
+func closeCodeOf(code int) int {
+
+	if code >= 400 && code < 500 {
+		return websocket.ClosePolicyViolation
+	}
+	return websocket.CloseInternalServerError
+}
+
+// readLoop decodes messages into IN values sent to in, and closes in after
+// the connection is closed.
+func (h *wsHandler) readLoop(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, in reflect.Value) {
+
+	defer cancel()
+	defer in.Close()
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: in},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		v := reflect.New(h.inType)
+		if err = json.Unmarshal(msg, v.Interface()); err != nil {
+			conn.CloseWithCode(websocket.CloseInvalidPayload, err.Error())
+			return
+		}
+		cases[0].Send = v.Elem()
+		if chosen, _, _ := reflect.Select(cases); chosen == 1 {
+			return
+		}
+	}
+}
+
+// writeLoop encodes OUT values received from out into messages, until stop is
+// closed. The method may close out when it has nothing more to send.
+func (h *wsHandler) writeLoop(conn *websocket.Conn, out reflect.Value, stop chan struct{}, cancel context.CancelFunc) {
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: out},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)},
+	}
+	broken := false
+	for {
+		chosen, v, recvOK := reflect.Select(cases)
+		if chosen == 1 {
+			return
+		}
+		if !recvOK { // out is closed; wait for stop only
+			cases[0].Chan = reflect.Value{}
+			continue
+		}
+		if broken { // keep receiving, so the method isn't blocked
+			continue
+		}
+		if err := conn.WriteJSON(v.Interface()); err != nil {
+			broken = true
+			cancel()
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
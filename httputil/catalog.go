@@ -0,0 +1,288 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* ---------------------------------------------------------------------------
+
+Catalog 是错误目录：每个错误有稳定的 errno、HTTP 状态码、供 SDK 生成常量的名字，以及多种语言的
+消息模板。模板按 fmt 的格式展开，可以用 %[n]s 调整参数的顺序：
+
+	var ErrBucketNotFound = httputil.DefaultCatalog.Register(&httputil.CatalogEntry{
+		Errno: 612, Code: 404, Name: "BucketNotFound",
+		Messages: map[string]string{
+			"en":    "bucket %s not found",
+			"zh-CN": "存储空间 %s 不存在",
+		},
+	})
+
+	func (p *Service) GetBuckets_(env *restrpc.Env) (ret bucketInfo, err error) {
+		...
+		return ret, ErrBucketNotFound.New(env.Args[0])
+	}
+
+New 返回的 *ErrorInfo 的消息使用目录的默认语言。Localize 按请求的 Accept-Language 重新展开
+消息。WithLanguage 在 ResponseWriter 上记录请求的 Accept-Language，之后 Error、ErrorWithCodec 与
+Problem 都按它回复本地化的消息；rpcutil 与 hfac 的 handler 都会调用 WithLanguage，ErrorFor 则直接
+按 req 本地化：
+
+	func (p *Service) DoFoo(w http.ResponseWriter, req *http.Request) {
+		...
+		httputil.Error(w, ErrBucketNotFound.New(bucket)) // 按 req 的 Accept-Language 回复
+	}
+
+目录可以通过 JSON、Markdown 导出，Catalog 本身也是一个以 JSON 回复目录的 http.Handler。
+
+// -------------------------------------------------------------------------*/
+
+// CatalogEntry describes an error of a Catalog.
+type CatalogEntry struct {
+	Errno    int               `json:"errno"`
+	Code     int               `json:"code"`     // http status code
+	Name     string            `json:"name"`     // eg. "BucketNotFound"
+	Messages map[string]string `json:"messages"` // fmt templates keyed by language tags, eg. "en" or "zh-CN"
+
+	cat *Catalog
+}
+
+// New creates an error of the entry, with its message in the default
+// language of the catalog.
+func (e *CatalogEntry) New(args ...interface{}) *ErrorInfo {
+
+	return &ErrorInfo{
+		Code:  e.Code,
+		Errno: e.Errno,
+		Err:   e.Message(e.defaultLang(), args...),
+		ext:   &errorExt{entry: e, args: args},
+	}
+}
+
+// defaultLang returns the default language of the catalog of the entry, or
+// of DefaultCatalog if the entry isn't registered in a catalog.
+func (e *CatalogEntry) defaultLang() string {
+
+	if e.cat == nil {
+		return DefaultCatalog.DefaultLang
+	}
+	return e.cat.DefaultLang
+}
+
+// Message renders the message of the entry in the language best matching
+// acceptLanguage (eg. "zh-CN,zh;q=0.9,en;q=0.8"), or the default language of
+// the catalog.
+func (e *CatalogEntry) Message(acceptLanguage string, args ...interface{}) string {
+
+	tmpl, ok := e.Messages[matchLanguage(acceptLanguage, e.Messages)]
+	if !ok {
+		tmpl = e.Messages[e.defaultLang()]
+	}
+	if len(args) == 0 {
+		return tmpl
+	}
+	return fmt.Sprintf(tmpl, args...)
+}
+
+// matchLanguage returns the language of messages best matching an
+// Accept-Language header, or "" if none matches.
+func matchLanguage(acceptLanguage string, messages map[string]string) string {
+
+	for _, r := range parseAccept(acceptLanguage) {
+		if r.typ == "*" {
+			return ""
+		}
+		for lang := range messages {
+			if strings.EqualFold(lang, r.typ) {
+				return lang
+			}
+		}
+		base := r.typ
+		if pos := strings.Index(base, "-"); pos >= 0 {
+			base = base[:pos]
+		}
+		var matched string
+		for lang := range messages {
+			lang1 := strings.ToLower(lang)
+			if lang1 == base || strings.HasPrefix(lang1, base+"-") {
+				if matched == "" || lang < matched { // deterministic
+					matched = lang
+				}
+			}
+		}
+		if matched != "" {
+			return matched
+		}
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+
+// Catalog is a catalog of errors with stable errnos.
+type Catalog struct {
+	DefaultLang string
+
+	mutex   sync.RWMutex
+	entries map[int]*CatalogEntry
+}
+
+// NewCatalog creates a Catalog whose default language is defaultLang.
+func NewCatalog(defaultLang string) *Catalog {
+
+	return &Catalog{DefaultLang: defaultLang, entries: make(map[int]*CatalogEntry)}
+}
+
+// DefaultCatalog is the default Catalog, in English by default.
+var DefaultCatalog = NewCatalog("en")
+
+// Register adds an entry to the catalog. It panics if the errno is registered
+// already, or the entry has no message in the default language.
+func (c *Catalog) Register(e *CatalogEntry) *CatalogEntry {
+
+	if _, ok := e.Messages[c.DefaultLang]; !ok {
+		panic("httputil: error " + strconv.Itoa(e.Errno) + " has no message in " + c.DefaultLang)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[e.Errno]; ok {
+		panic("httputil: errno registered twice: " + strconv.Itoa(e.Errno))
+	}
+	e.cat = c
+	c.entries[e.Errno] = e
+	return e
+}
+
+// Lookup returns the entry of an errno, or nil if none.
+func (c *Catalog) Lookup(errno int) *CatalogEntry {
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.entries[errno]
+}
+
+// Entries returns the entries of the catalog sorted by errno.
+func (c *Catalog) Entries() []*CatalogEntry {
+
+	c.mutex.RLock()
+	entries := make([]*CatalogEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	c.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Errno < entries[j].Errno })
+	return entries
+}
+
+// JSON exports the catalog as a JSON array sorted by errno.
+func (c *Catalog) JSON() ([]byte, error) {
+
+	return json.MarshalIndent(c.Entries(), "", "  ")
+}
+
+// Markdown exports the catalog as a Markdown table sorted by errno, with one
+// column per language (the default language first).
+func (c *Catalog) Markdown() []byte {
+
+	entries := c.Entries()
+	langs := []string{c.DefaultLang}
+	seen := map[string]bool{c.DefaultLang: true}
+	var others []string
+	for _, e := range entries {
+		for lang := range e.Messages {
+			if !seen[lang] {
+				seen[lang] = true
+				others = append(others, lang)
+			}
+		}
+	}
+	sort.Strings(others)
+	langs = append(langs, others...)
+
+	var b bytes.Buffer
+	b.WriteString("| Errno | Name | Code | " + strings.Join(langs, " | ") + " |\n")
+	b.WriteString("|---|---|---|" + strings.Repeat("---|", len(langs)) + "\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "| %d | %s | %d |", e.Errno, e.Name, e.Code)
+		for _, lang := range langs {
+			b.WriteString(" " + strings.Replace(e.Messages[lang], "|", `\|`, -1) + " |")
+		}
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+// ServeHTTP replies the catalog in JSON.
+func (c *Catalog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	Reply(w, 200, c.Entries())
+}
+
+// ---------------------------------------------------------------------------
+
+// Localize renders the message of an error created by CatalogEntry.New in the
+// language best matching the Accept-Language header of req. Other errors are
+// returned as is.
+func Localize(err error, req *http.Request) error {
+
+	return localize(err, req.Header.Get("Accept-Language"))
+}
+
+// WithLanguage returns a http.ResponseWriter which writes to w, with which
+// Error, ErrorWithCodec and Problem reply the messages of errors in a Catalog
+// in the language best matching acceptLanguage (eg. the Accept-Language
+// header of the request), see Localize. Like TrackResponse, the writer
+// records whether the response has started.
+func WithLanguage(w http.ResponseWriter, acceptLanguage string) http.ResponseWriter {
+
+	tw, ok := w.(*trackWriter)
+	if !ok {
+		tw = &trackWriter{ResponseWriter: w}
+	}
+	tw.lang = acceptLanguage
+	return tw
+}
+
+// languageOf returns the language recorded by WithLanguage in w, or in the
+// writers w wraps.
+func languageOf(w http.ResponseWriter) string {
+
+	for {
+		switch v := w.(type) {
+		case *trackWriter:
+			if v.lang != "" {
+				return v.lang
+			}
+			w = v.ResponseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+func localize(err error, acceptLanguage string) error {
+
+	e := errorInfoOf(err)
+	if e == nil || e.ext == nil || e.ext.entry == nil || acceptLanguage == "" {
+		return err
+	}
+	msg := e.ext.entry.Message(acceptLanguage, e.ext.args...)
+	if msg == e.Err {
+		return err
+	}
+	e1 := *e
+	e1.Err = msg
+	return &e1
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,109 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+
+func newTestCatalog() (*Catalog, *CatalogEntry) {
+
+	cat := NewCatalog("en")
+	e := cat.Register(&CatalogEntry{
+		Errno: 612, Code: 404, Name: "BucketNotFound",
+		Messages: map[string]string{
+			"en":    "bucket %s not found",
+			"zh-CN": "存储空间 %s 不存在",
+		},
+	})
+	cat.Register(&CatalogEntry{
+		Errno: 400, Code: 400, Name: "InvalidArgs",
+		Messages: map[string]string{"en": "invalid args: %[2]s (%[1]d|x)", "fr": "arguments invalides: %[2]s"},
+	})
+	return cat, e
+}
+
+func TestCatalogMessage(t *testing.T) {
+
+	_, e := newTestCatalog()
+	cases := [][2]string{
+		{"", "bucket b1 not found"},
+		{"zh-CN", "存储空间 b1 不存在"},
+		{"zh-cn,en;q=0.5", "存储空间 b1 不存在"},
+		{"zh", "存储空间 b1 不存在"},
+		{"zh-TW", "存储空间 b1 不存在"},
+		{"fr, en;q=0.1, zh;q=0.5", "存储空间 b1 不存在"},
+		{"fr, *;q=0.5", "bucket b1 not found"},
+		{"de", "bucket b1 not found"},
+	}
+	for _, c := range cases {
+		if msg := e.Message(c[0], "b1"); msg != c[1] {
+			t.Fatal("Message:", c[0], msg)
+		}
+	}
+
+	err := e.New("b1")
+	if err.Code != 404 || err.Errno != 612 || err.Error() != "bucket b1 not found" {
+		t.Fatal("New:", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "zh-CN")
+	if err1 := Localize(err, req); err1.Error() != "存储空间 b1 不存在" || err.Error() != "bucket b1 not found" {
+		t.Fatal("Localize:", err1, err)
+	}
+
+	w := httptest.NewRecorder()
+	ErrorFor(w, req, err)
+	if w.Code != 404 || w.Body.String() != `{"error":"存储空间 b1 不存在","errno":612}` {
+		t.Fatal("ErrorFor:", w.Code, w.Body.String())
+	}
+
+	replies := []struct {
+		reply func(w http.ResponseWriter, err error)
+		resp  string
+	}{
+		{Error, `{"error":"存储空间 b1 不存在","errno":612}`},
+		{Problem, `{"detail":"存储空间 b1 不存在","errno":612,"status":404,"title":"Not Found","type":"about:blank"}`},
+		{func(w http.ResponseWriter, err error) { ErrorWithCodec(w, err, "application/json", JSONCodec) },
+			`{"error":"存储空间 b1 不存在","errno":612}`},
+	}
+	for i, c := range replies {
+		w = httptest.NewRecorder()
+		c.reply(WithLanguage(w, "zh-CN"), err)
+		if w.Code != 404 || strings.TrimSpace(w.Body.String()) != c.resp {
+			t.Fatal(i, "unexpected localized reply:", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCatalogEntryNotRegistered(t *testing.T) {
+
+	e := &CatalogEntry{Errno: 613, Code: 404, Messages: map[string]string{"en": "object %s not found"}}
+	if err := e.New("a"); err.Code != 404 || err.Errno != 613 || err.Error() != "object a not found" {
+		t.Fatal("New:", err)
+	}
+}
+
+func TestCatalogExport(t *testing.T) {
+
+	cat, _ := newTestCatalog()
+
+	b, err := cat.JSON()
+	if err != nil || !strings.HasPrefix(string(b), "[\n  {\n    \"errno\": 400,") {
+		t.Fatal("JSON:", string(b), err)
+	}
+
+	expected := "| Errno | Name | Code | en | fr | zh-CN |\n" +
+		"|---|---|---|---|---|---|\n" +
+		"| 400 | InvalidArgs | 400 | invalid args: %[2]s (%[1]d\\|x) | arguments invalides: %[2]s |  |\n" +
+		"| 612 | BucketNotFound | 404 | bucket %s not found |  | 存储空间 %s 不存在 |\n"
+	if md := string(cat.Markdown()); md != expected {
+		t.Fatal("Markdown:\n" + md)
+	}
+}
+
+// ---------------------------------------------------------------------------
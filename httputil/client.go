@@ -0,0 +1,40 @@
+package httputil
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+
+const maxErrorBody = 64 << 10
+
+// ResponseError decodes an error reply of a http response into an *ErrorInfo,
+// either replied by Error or by Problem. It reads and closes resp.Body.
+func ResponseError(resp *http.Response) error {
+
+	defer resp.Body.Close()
+
+	e := &ErrorInfo{Code: resp.StatusCode}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil || len(b) == 0 {
+		return e
+	}
+
+	if MediaType(resp.Header.Get("Content-Type")) == ProblemMediaType && decodeProblem(e, b) {
+		return e
+	}
+
+	var ret errorRet
+	if json.Unmarshal(b, &ret) == nil && ret.Err != "" {
+		e.Err, e.Errno = ret.Err, ret.Errno
+	} else if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		e.Err = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,258 @@
+package httputil
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* ---------------------------------------------------------------------------
+
+Codec 按媒体类型(media type)编解码请求与响应的 body。内置 JSON(application/json)与
+XML(application/xml, text/xml)，其余格式通过 RegisterCodec 注册，例如 MessagePack：
+
+	type msgpackCodec struct{}
+
+	func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+	func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+	httputil.RegisterCodec("application/msgpack", msgpackCodec{})
+
+带结构化后缀的类型(如 application/vnd.foo+json、application/atom+xml)按后缀使用 JSON 或 XML。
+
+- 请求：restrpc 按 Content-Type 选择 Codec 解析 args，未注册的类型返回 415。
+- 响应：Negotiate 按 Accept(含 q 值)选择 Codec，没有可接受的类型时返回 406。只接受
+  application/problem+json 的请求，成功的响应使用 JSON。
+  rpcutil.NegotiateRepl 是按 Accept 选择响应格式的 Replier。
+
+// -------------------------------------------------------------------------*/
+
+// Codec encodes and decodes values in a media type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error)      { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// JSONCodec and XMLCodec are the built-in codecs.
+var (
+	JSONCodec Codec = jsonCodec{}
+	XMLCodec  Codec = xmlCodec{}
+)
+
+var (
+	codecMutex sync.RWMutex
+	codecs     = map[string]Codec{
+		"application/json": JSONCodec,
+		"application/xml":  XMLCodec,
+		"text/xml":         XMLCodec,
+	}
+	codecTypes = []string{"application/json", "application/xml", "text/xml"} // in order of registration
+)
+
+// RegisterCodec registers the codec of a media type, eg. "application/msgpack".
+// It replaces the codec registered before for the same media type.
+func RegisterCodec(mediaType string, codec Codec) {
+
+	mediaType = strings.ToLower(mediaType)
+
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+	if _, ok := codecs[mediaType]; !ok {
+		codecTypes = append(codecTypes, mediaType)
+	}
+	codecs[mediaType] = codec
+}
+
+// MediaType returns the media type of a Content-Type header, without
+// parameters, eg. "application/json" for "application/json; charset=utf-8".
+func MediaType(contentType string) string {
+
+	if pos := strings.Index(contentType, ";"); pos >= 0 {
+		contentType = contentType[:pos]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// CodecOf returns the codec of a media type, or nil if none is registered.
+func CodecOf(mediaType string) Codec {
+
+	mediaType = MediaType(mediaType)
+
+	codecMutex.RLock()
+	codec, ok := codecs[mediaType]
+	codecMutex.RUnlock()
+	if ok {
+		return codec
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return JSONCodec
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return XMLCodec
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+
+// ErrNotAcceptable is replied if no media type acceptable to a request is
+// supported.
+var ErrNotAcceptable = NewError(http.StatusNotAcceptable, "not acceptable")
+
+// ErrUnsupportedMediaType is replied if the media type of a request body is
+// not supported.
+var ErrUnsupportedMediaType = NewError(http.StatusUnsupportedMediaType, "unsupported media type")
+
+type mediaRange struct {
+	typ string
+	q   float64
+}
+
+func parseAccept(accept string) (ranges []mediaRange) {
+
+	for _, r := range parseQValues(accept) {
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return
+}
+
+// parseQValues parses a header of items with q values, eg. Accept or
+// Accept-Encoding, keeping items of q=0.
+func parseQValues(accept string) (ranges []mediaRange) {
+
+	for _, item := range strings.Split(accept, ",") {
+		parts := strings.Split(item, ";")
+		r := mediaRange{typ: strings.ToLower(strings.TrimSpace(parts[0])), q: 1}
+		if r.typ == "" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return
+}
+
+// Negotiate picks the media type of the response to req, by the Accept header
+// of req among the registered codecs. JSON is picked if req has no Accept
+// header or accepts any type; ErrNotAcceptable is returned if none of the
+// acceptable types is supported. application/problem+json is for errors
+// (see AcceptsProblem), so JSON is picked if it is the only acceptable type.
+func Negotiate(req *http.Request) (mediaType string, codec Codec, err error) {
+
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return "application/json", JSONCodec, nil
+	}
+
+	problem := false
+	for _, r := range parseAccept(accept) {
+		switch {
+		case r.typ == ProblemMediaType: // only for errors, see AcceptsProblem
+			problem = true
+		case r.typ == "*/*":
+			return "application/json", JSONCodec, nil
+		case strings.HasSuffix(r.typ, "/*"):
+			prefix := r.typ[:len(r.typ)-1]
+			codecMutex.RLock()
+			for _, typ := range codecTypes {
+				if strings.HasPrefix(typ, prefix) {
+					codec = codecs[typ]
+					codecMutex.RUnlock()
+					return typ, codec, nil
+				}
+			}
+			codecMutex.RUnlock()
+		default:
+			if codec = CodecOf(r.typ); codec != nil {
+				return r.typ, codec, nil
+			}
+		}
+	}
+	if problem {
+		return "application/json", JSONCodec, nil
+	}
+	return "", nil, ErrNotAcceptable
+}
+
+// ---------------------------------------------------------------------------
+
+// ReplyWithCodec replies data encoded by codec in mediaType.
+func ReplyWithCodec(w http.ResponseWriter, code int, mediaType string, codec Codec, data interface{}) {
+
+	msg, err := codec.Marshal(data)
+	if err != nil {
+		ErrorWithCodec(w, err, mediaType, codec)
+		return
+	}
+	ReplyWith(w, code, mediaType, msg)
+}
+
+// ErrorWithCodec replies an error encoded by codec in mediaType, the same as
+// Error does in JSON.
+func ErrorWithCodec(w http.ResponseWriter, err error, mediaType string, codec Codec) {
+
+	if err == nil {
+		ReplyWithCodeCodec(w, 200, mediaType, codec)
+		return
+	}
+
+	code, errno, errmsg := GetErrorInfo(localize(err, languageOf(w)))
+	msg, err2 := codec.Marshal(&errorRet{Err: errmsg, Errno: errno})
+	if err2 != nil {
+		Reply(w, code, &errorRet{Err: errmsg, Errno: errno})
+		return
+	}
+	ReplyWith(w, code, mediaType, msg)
+}
+
+// ReplyWithCodeCodec replies a http response with no data, the same as
+// ReplyWithCode does in JSON.
+func ReplyWithCodeCodec(w http.ResponseWriter, code int, mediaType string, codec Codec) {
+
+	if !BodyAllowed(code) {
+		w.WriteHeader(code)
+		return
+	}
+	if code >= 400 {
+		err := http.StatusText(code)
+		if err == "" {
+			err = "E" + strconv.Itoa(code)
+		}
+		ErrorWithCodec(w, NewError(code, err), mediaType, codec)
+		return
+	}
+	if codec == JSONCodec {
+		ReplyWith(w, code, mediaType, emptyObj)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Length", "0")
+	h.Set("Content-Type", mediaType)
+	w.WriteHeader(code)
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,435 @@
+package httputil
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* ---------------------------------------------------------------------------
+
+Compression 是压缩响应的 middleware，按请求的 Accept-Encoding(含 q 值)选择编码：
+
+	mux := router.Register(service)
+	http.ListenAndServe(addr, httputil.Compress(mux))
+
+- 只压缩 ContentTypes 中的类型(缺省为 JSON、XML、JavaScript 与 text/*，以及 +json、+xml 后缀
+  的类型)，这些响应都带 Vary: Accept-Encoding。
+- Content-Length 小于 MinSize(缺省 1024)的响应不压缩；没有 Content-Length 的响应先缓存
+  MinSize 字节再决定。压缩的响应去掉 Content-Length 与 Accept-Ranges，强 ETag 变为弱 ETag。
+- HEAD 请求、206 响应、已经设置了 Content-Encoding 的响应不压缩。
+- 路由可以在写响应之前调用 NoCompress(w) 关闭压缩，例如已经压缩过的数据。
+
+内置 gzip，br、zstd 等编码通过 RegisterEncoding 注册，例如：
+
+	httputil.RegisterEncoding(&httputil.Encoding{
+		Name:      "br",
+		NewWriter: func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(brotli.NewReader(r)), nil },
+	})
+
+请求的 body 如果有 Content-Encoding，restrpc 按 Codec 或 form 解析请求时先按 DecodeRequest 解压，
+不支持的编码返回 415；ReqBody 为 io.ReadCloser 或 []byte 的方法得到原始的 body。解压后的 body 不能超过 MaxDecodedBodySize(默认 32 MiB)，超过时读取 body 返回 413，
+以防压缩炸弹耗尽内存。
+
+// -------------------------------------------------------------------------*/
+
+// Encoding is a content coding, see RegisterEncoding.
+type Encoding struct {
+	Name      string                                   // eg. "gzip", "br" or "zstd"
+	NewWriter func(w io.Writer) io.WriteCloser         // compresses responses; nil if not supported
+	NewReader func(r io.Reader) (io.ReadCloser, error) // decompresses requests; nil if not supported
+}
+
+var gzipEncoding = &Encoding{
+	Name:      "gzip",
+	NewWriter: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}
+
+var (
+	encodingMutex sync.RWMutex
+	encodings     = []*Encoding{gzipEncoding}
+)
+
+// RegisterEncoding registers a content coding. It replaces the encoding
+// registered before with the same name. Encodings registered later are
+// preferred if a request accepts several of them equally.
+func RegisterEncoding(e *Encoding) {
+
+	encodingMutex.Lock()
+	defer encodingMutex.Unlock()
+	for i, e1 := range encodings {
+		if strings.EqualFold(e1.Name, e.Name) {
+			encodings = append(encodings[:i:i], encodings[i+1:]...)
+			break
+		}
+	}
+	encodings = append(encodings, e)
+}
+
+func encodingOf(name string) *Encoding {
+
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	encodingMutex.RLock()
+	defer encodingMutex.RUnlock()
+	for _, e := range encodings {
+		if strings.EqualFold(e.Name, name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// negotiateEncoding picks the encoding of a response by an Accept-Encoding
+// header, or nil if none is acceptable.
+func negotiateEncoding(acceptEncoding string) *Encoding {
+
+	if acceptEncoding == "" {
+		return nil
+	}
+	values := parseQValues(acceptEncoding)
+	qOf := func(name string) float64 {
+		star := 0.0
+		for _, v := range values {
+			if v.typ == name || (name == "gzip" && v.typ == "x-gzip") {
+				return v.q
+			}
+			if v.typ == "*" {
+				star = v.q
+			}
+		}
+		return star
+	}
+
+	var best *Encoding
+	var bestQ float64
+	encodingMutex.RLock()
+	defer encodingMutex.RUnlock()
+	for i := len(encodings) - 1; i >= 0; i-- {
+		e := encodings[i]
+		if e.NewWriter == nil {
+			continue
+		}
+		if q := qOf(strings.ToLower(e.Name)); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// ---------------------------------------------------------------------------
+
+// ErrUnsupportedEncoding is replied if the Content-Encoding of a request body
+// is not supported.
+var ErrUnsupportedEncoding = NewError(http.StatusUnsupportedMediaType, "unsupported content encoding")
+
+// MaxDecodedBodySize limits the size of a request body decompressed by
+// DecodeRequest; reading more of it returns ErrDecodedBodyTooLarge. There
+// is no limit if it is 0 or negative.
+var MaxDecodedBodySize int64 = 32 << 20
+
+// ErrDecodedBodyTooLarge is returned when reading a request body decompressed
+// by DecodeRequest if it is larger than MaxDecodedBodySize.
+var ErrDecodedBodyTooLarge = NewError(http.StatusRequestEntityTooLarge, "decoded request body too large")
+
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+// limitedBody is a request body returning ErrDecodedBodyTooLarge after n
+// bytes, like http.MaxBytesReader.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (p *limitedBody) Read(b []byte) (int, error) {
+
+	if int64(len(b)) > p.n+1 {
+		b = b[:p.n+1]
+	}
+	n, err := p.ReadCloser.Read(b)
+	if int64(n) <= p.n {
+		p.n -= int64(n)
+		return n, err
+	}
+	n, p.n = int(p.n), 0
+	return n, ErrDecodedBodyTooLarge
+}
+
+func (p *decodedBody) Close() error {
+
+	p.ReadCloser.Close()
+	return p.body.Close()
+}
+
+// DecodeRequest replaces the body of req with the decompressed one if req has
+// a Content-Encoding (eg. gzip), and removes the Content-Encoding and
+// Content-Length of req. It returns ErrUnsupportedEncoding if the encoding is
+// not registered (see RegisterEncoding). The decompressed body is limited by
+// MaxDecodedBodySize.
+func DecodeRequest(req *http.Request) error {
+
+	ce := req.Header.Get("Content-Encoding")
+	if ce == "" || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	names := strings.Split(ce, ",")
+	var r io.ReadCloser = req.Body
+	for i := len(names) - 1; i >= 0; i-- { // in reverse order of the codings applied
+		name := strings.ToLower(strings.TrimSpace(names[i]))
+		if name == "identity" || name == "" {
+			continue
+		}
+		e := encodingOf(name)
+		if e == nil || e.NewReader == nil {
+			return ErrUnsupportedEncoding
+		}
+		zr, err := e.NewReader(r)
+		if err != nil {
+			return NewError(400, "invalid "+name+" body: "+err.Error())
+		}
+		r = &decodedBody{zr, r}
+	}
+	if r != req.Body && MaxDecodedBodySize > 0 {
+		r = &limitedBody{r, MaxDecodedBodySize}
+	}
+	req.Body = r
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+
+// DefaultCompressTypes are the media types compressed by default, see
+// Compression. A type ending with "/*" matches all of its subtypes.
+var DefaultCompressTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"image/svg+xml",
+	"text/*",
+}
+
+// Compression compresses responses of a http.Handler, see Handler.
+type Compression struct {
+	MinSize      int      // responses smaller than it are not compressed; 1024 if 0
+	ContentTypes []string // media types compressed; DefaultCompressTypes if nil
+}
+
+// Compress compresses responses of h by the default Compression.
+func Compress(h http.Handler) http.Handler {
+
+	return (&Compression{}).Handler(h)
+}
+
+// Handler returns a http.Handler compressing responses of h in the encoding
+// negotiated by the Accept-Encoding header of the request.
+func (c *Compression) Handler(h http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cw := &compressWriter{ResponseWriter: w, c: c, head: req.Method == "HEAD"}
+		cw.enc = negotiateEncoding(req.Header.Get("Accept-Encoding"))
+		h.ServeHTTP(cw, req)
+		cw.close() // not deferred: an aborted response must not end as if complete
+	})
+}
+
+func (c *Compression) minSize() int {
+
+	if c.MinSize == 0 {
+		return 1024
+	}
+	return c.MinSize
+}
+
+func (c *Compression) compressible(contentType string) bool {
+
+	mediaType := MediaType(contentType)
+	if mediaType == "" {
+		return false
+	}
+	types := c.ContentTypes
+	if types == nil {
+		types = DefaultCompressTypes
+		if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+			return true
+		}
+	}
+	for _, typ := range types {
+		if typ == mediaType || (strings.HasSuffix(typ, "/*") && strings.HasPrefix(mediaType, typ[:len(typ)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// NoCompress turns off compression of the response written to w, see
+// Compression. It must be called before the header is written.
+func NoCompress(w http.ResponseWriter) {
+
+	for {
+		switch v := w.(type) {
+		case *compressWriter:
+			v.off = true
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+const (
+	stateInit = iota
+	statePending
+	stateCompress
+	statePlain
+)
+
+type compressWriter struct {
+	http.ResponseWriter
+	c     *Compression
+	enc   *Encoding // nil if no encoding is acceptable
+	zw    io.WriteCloser
+	buf   []byte
+	code  int
+	state int
+	head  bool
+	off   bool
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+
+	if code < 200 { // eg. 103 Early Hints
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.state != stateInit {
+		return
+	}
+	w.code = code
+
+	h := w.Header()
+	if !w.off && h.Get("Content-Encoding") == "" && w.c.compressible(h.Get("Content-Type")) {
+		addVary(h, "Accept-Encoding")
+		if w.enc != nil && !w.head && code != http.StatusPartialContent && BodyAllowed(code) {
+			cl := h.Get("Content-Length")
+			if cl == "" {
+				w.state = statePending
+				return
+			}
+			if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= int64(w.c.minSize()) {
+				w.startCompress()
+				return
+			}
+		}
+	}
+	w.state = statePlain
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) startCompress() {
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.enc.Name)
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag) // the compressed body is not byte-for-byte the same
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	w.zw = w.enc.NewWriter(w.ResponseWriter)
+	w.state = stateCompress
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+
+	if w.state == stateInit {
+		h := w.Header()
+		if _, ok := h["Content-Type"]; !ok {
+			h.Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(200)
+	}
+
+	switch w.state {
+	case statePending:
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.c.minSize() {
+			w.startCompress()
+			buf := w.buf
+			w.buf = nil
+			if _, err := w.zw.Write(buf); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	case stateCompress:
+		return w.zw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush writes the buffered data to the client, see http.Flusher.
+func (w *compressWriter) Flush() {
+
+	if w.state == statePending {
+		w.startCompress()
+		w.zw.Write(w.buf)
+		w.buf = nil
+	}
+	if w.state == stateCompress {
+		if f, ok := w.zw.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) close() {
+
+	switch w.state {
+	case statePending: // smaller than MinSize
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.ResponseWriter.WriteHeader(w.code)
+		w.ResponseWriter.Write(w.buf)
+	case stateCompress:
+		w.zw.Close()
+	}
+}
+
+func addVary(h http.Header, name string) {
+
+	for _, v := range h["Vary"] {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// ---------------------------------------------------------------------------
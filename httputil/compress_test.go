@@ -0,0 +1,170 @@
+package httputil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+
+type upperWriter struct{ w io.Writer }
+
+func (p upperWriter) Write(b []byte) (int, error) { return p.w.Write(bytes.ToUpper(b)) }
+func (p upperWriter) Close() error                { return nil }
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	RegisterEncoding(&Encoding{Name: "upper", NewWriter: func(w io.Writer) io.WriteCloser { return upperWriter{w} }})
+	defer RegisterEncoding(&Encoding{Name: "upper"})
+
+	cases := [][2]string{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"identity", ""},
+		{"*", "upper"}, // registered later, preferred
+		{"gzip, upper", "upper"},
+		{"gzip, upper;q=0.5", "gzip"},
+		{"*;q=0.5, gzip", "gzip"},
+		{"gzip;q=0, *", "upper"},
+		{"upper;q=0, gzip;q=0", ""},
+	}
+	for _, c := range cases {
+		name := ""
+		if e := negotiateEncoding(c[0]); e != nil {
+			name = e.Name
+		}
+		if name != c[1] {
+			t.Fatal("negotiateEncoding:", c[0], "=>", name, "expected:", c[1])
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+
+	large := strings.Repeat(`{"name":"foo"},`, 100)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/large":
+			w.Header().Set("Etag", `"v1"`)
+			ReplyWith(w, 200, "application/json", []byte(large))
+		case "/small":
+			ReplyWith(w, 200, "application/json", []byte(`{}`))
+		case "/stream": // no Content-Length
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, large[:600])
+			io.WriteString(w, large[600:])
+		case "/stream-small":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello")
+		case "/image":
+			ReplyWith(w, 200, "image/png", []byte(large))
+		case "/off":
+			NoCompress(w)
+			ReplyWith(w, 200, "application/json", []byte(large))
+		case "/empty":
+			ReplyWithCode(w, 204)
+		}
+	}
+	h := Compress(http.HandlerFunc(handler))
+
+	cases := []struct {
+		method, path, acceptEncoding string
+		encoding, vary, clen, etag   string
+		resp                         string
+	}{
+		{"GET", "/large", "gzip", "gzip", "Accept-Encoding", "", `W/"v1"`, large},
+		{"GET", "/large", "", "", "Accept-Encoding", "1500", `"v1"`, large},
+		{"HEAD", "/large", "gzip", "", "Accept-Encoding", "1500", `"v1"`, large}, // the recorder keeps the body of HEAD
+		{"GET", "/small", "gzip", "", "Accept-Encoding", "2", "", `{}`},
+		{"GET", "/stream", "gzip", "gzip", "Accept-Encoding", "", "", large},
+		{"GET", "/stream-small", "gzip", "", "Accept-Encoding", "5", "", "hello"},
+		{"GET", "/image", "gzip", "", "", "1500", "", large},
+		{"GET", "/off", "gzip", "", "", "1500", "", large},
+		{"GET", "/empty", "gzip", "", "", "", "", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", c.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		hdr := w.Header()
+		if hdr.Get("Content-Encoding") != c.encoding || hdr.Get("Vary") != c.vary ||
+			hdr.Get("Content-Length") != c.clen || hdr.Get("Etag") != c.etag {
+			t.Fatal("unexpected header:", c.method, c.path, hdr)
+		}
+		body := w.Body.Bytes()
+		if c.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal("gzip.NewReader failed:", c.path, err)
+			}
+			if body, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatal("read gzip body failed:", c.path, err)
+			}
+		}
+		if string(body) != c.resp {
+			t.Fatal("unexpected body:", c.path, string(body))
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(`{"a":1}`))
+	zw.Close()
+
+	req := httptest.NewRequest("POST", "/foo", bytes.NewReader(b.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	if err := DecodeRequest(req); err != nil {
+		t.Fatal("DecodeRequest failed:", err)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil || string(body) != `{"a":1}` || req.ContentLength != -1 || req.Header.Get("Content-Encoding") != "" {
+		t.Fatal("unexpected body:", string(body), err, req.ContentLength, req.Header)
+	}
+
+	req = httptest.NewRequest("POST", "/foo", strings.NewReader("xxx"))
+	req.Header.Set("Content-Encoding", "compress")
+	if err := DecodeRequest(req); err != ErrUnsupportedEncoding {
+		t.Fatal("DecodeRequest of unknown encoding:", err)
+	}
+
+	b.Reset()
+	zw = gzip.NewWriter(&b)
+	zw.Write(make([]byte, 1<<20)) // 1 MiB of zeros, about 1 KiB gzipped
+	zw.Close()
+
+	defer func(max int64) { MaxDecodedBodySize = max }(MaxDecodedBodySize)
+	limits := []struct {
+		max  int64
+		size int
+		err  error
+	}{
+		{64 << 10, 64 << 10, ErrDecodedBodyTooLarge},
+		{1 << 20, 1 << 20, nil},
+		{0, 1 << 20, nil},
+	}
+	for _, c := range limits {
+		MaxDecodedBodySize = c.max
+		req = httptest.NewRequest("POST", "/foo", bytes.NewReader(b.Bytes()))
+		req.Header.Set("Content-Encoding", "gzip")
+		DecodeRequest(req)
+		body, err = ioutil.ReadAll(req.Body)
+		if err != c.err || len(body) != c.size {
+			t.Fatal("MaxDecodedBodySize:", c.max, len(body), err)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
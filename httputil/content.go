@@ -0,0 +1,98 @@
+package httputil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+/* ---------------------------------------------------------------------------
+
+ServeContent 回复一个可随机读取的资源(如文件、对象存储的一个对象)，支持：
+
+- Range：单个区间回复 206，多个区间回复 multipart/byteranges，无法满足的区间回复 416。
+- If-Range：ETag 或 Last-Modified 不匹配时忽略 Range，回复整个资源。
+- If-None-Match、If-Modified-Since：资源没有变化时回复 304(GET、HEAD)。
+- If-Match、If-Unmodified-Since：前置条件不满足时回复 412。
+
+资源由 Content 描述，可以是 io.ReadSeeker，也可以是大小已知的 io.ReaderAt：
+
+	func (p *Service) GetObjects_(env *restrpc.Env) (ret *httputil.Content, err error) {
+		obj, err := p.bucket.Open(env.Args[0])
+		if err != nil {
+			return
+		}
+		return &httputil.Content{
+			ContentType: obj.MimeType, ETag: `"` + obj.Hash + `"`, LastModified: obj.PutTime,
+			ReaderAt: obj, Size: obj.Fsize,
+		}, nil
+	}
+
+rpcutil 的方法返回 Content(或 Response.Body 为 Content)时按 ServeContent 回复，状态码由
+ServeContent 决定。
+
+// -------------------------------------------------------------------------*/
+
+// Content describes a resource replied by ServeContent. Either ReadSeeker or
+// ReaderAt (with Size) must be set. The reader is closed after replied if it
+// implements io.Closer.
+type Content struct {
+	ContentType  string    // detected from the content if empty
+	ETag         string    // eg. `"xyzzy"` or `W/"xyzzy"`; no ETag if empty
+	LastModified time.Time // no Last-Modified if zero
+
+	ReadSeeker io.ReadSeeker
+	ReaderAt   io.ReaderAt
+	Size       int64 // size of ReaderAt
+}
+
+var errNoContentReader = errors.New("httputil: Content has no reader")
+
+// Reader returns the reader of the content.
+func (c *Content) Reader() (io.ReadSeeker, error) {
+
+	if c.ReadSeeker != nil {
+		return c.ReadSeeker, nil
+	}
+	if c.ReaderAt != nil {
+		return io.NewSectionReader(c.ReaderAt, 0, c.Size), nil
+	}
+	return nil, errNoContentReader
+}
+
+// Close closes the reader of the content if it implements io.Closer.
+func (c *Content) Close() error {
+
+	if closer, ok := c.ReadSeeker.(io.Closer); ok {
+		return closer.Close()
+	}
+	if closer, ok := c.ReaderAt.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ServeContent replies the content, handling Range, If-Range, If-Match,
+// If-None-Match, If-Modified-Since and If-Unmodified-Since of req (see
+// http.ServeContent). It closes the content.
+func ServeContent(w http.ResponseWriter, req *http.Request, c *Content) {
+
+	defer c.Close()
+
+	r, err := c.Reader()
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	h := w.Header()
+	if c.ContentType != "" {
+		h.Set("Content-Type", c.ContentType)
+	}
+	if c.ETag != "" {
+		h.Set("Etag", c.ETag)
+	}
+	http.ServeContent(w, req, "", c.LastModified, r)
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,103 @@
+package httputil
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+
+type closeTracker struct {
+	*strings.Reader
+	closed bool
+}
+
+func (p *closeTracker) Close() error {
+
+	p.closed = true
+	return nil
+}
+
+func TestServeContent(t *testing.T) {
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	lastModified := modTime.Format(http.TimeFormat)
+
+	cases := []struct {
+		method string
+		header map[string]string
+		code   int
+		crange string
+		resp   string
+	}{
+		{"GET", nil, 200, "", "0123456789"},
+		{"HEAD", nil, 200, "", ""},
+		{"GET", map[string]string{"Range": "bytes=2-4"}, 206, "bytes 2-4/10", "234"},
+		{"GET", map[string]string{"Range": "bytes=-3"}, 206, "bytes 7-9/10", "789"},
+		{"GET", map[string]string{"Range": "bytes=20-"}, 416, "bytes */10", ""},
+		{"GET", map[string]string{"Range": "bytes=2-4", "If-Range": `"v1"`}, 206, "bytes 2-4/10", "234"},
+		{"GET", map[string]string{"Range": "bytes=2-4", "If-Range": `"v0"`}, 200, "", "0123456789"},
+		{"GET", map[string]string{"Range": "bytes=2-4", "If-Range": lastModified}, 206, "bytes 2-4/10", "234"},
+		{"GET", map[string]string{"If-None-Match": `"v0", "v1"`}, 304, "", ""},
+		{"GET", map[string]string{"If-None-Match": `"v0"`}, 200, "", "0123456789"},
+		{"GET", map[string]string{"If-Modified-Since": lastModified}, 304, "", ""},
+		{"GET", map[string]string{"If-Match": `"v0"`}, 412, "", ""},
+		{"GET", map[string]string{"If-Match": `"v1"`}, 200, "", "0123456789"},
+		{"GET", map[string]string{"If-Unmodified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, 412, "", ""},
+	}
+	for i, c := range cases {
+		r := &closeTracker{Reader: strings.NewReader("0123456789")}
+		req := httptest.NewRequest(c.method, "/foo", nil)
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		ServeContent(w, req, &Content{ContentType: "text/plain", ETag: `"v1"`, LastModified: modTime, ReaderAt: r, Size: 10})
+		if w.Code != c.code || w.Header().Get("Content-Range") != c.crange {
+			t.Fatal("unexpected resp:", i, w.Code, w.Header())
+		}
+		if c.code/100 == 2 && w.Body.String() != c.resp {
+			t.Fatal("unexpected body:", i, w.Body.String())
+		}
+		if w.Code == 200 && (w.Header().Get("Etag") != `"v1"` || w.Header().Get("Last-Modified") != lastModified) {
+			t.Fatal("unexpected validators:", i, w.Header())
+		}
+		if !r.closed {
+			t.Fatal("content not closed:", i)
+		}
+	}
+}
+
+func TestServeContentMultipart(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("Range", "bytes=0-1,5-6")
+	w := httptest.NewRecorder()
+	ServeContent(w, req, &Content{ContentType: "text/plain", ReadSeeker: strings.NewReader("0123456789")})
+	if w.Code != 206 {
+		t.Fatal("unexpected code:", w.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatal("unexpected Content-Type:", w.Header().Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range [][2]string{{"bytes 0-1/10", "01"}, {"bytes 5-6/10", "56"}} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal("NextPart failed:", err)
+		}
+		b, _ := ioutil.ReadAll(part)
+		if part.Header.Get("Content-Range") != want[0] || string(b) != want[1] {
+			t.Fatal("unexpected part:", part.Header, string(b))
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -1,10 +1,15 @@
 package httputil
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"sync"
 	"syscall"
 )
 
@@ -16,6 +21,20 @@ type ErrorInfo struct {
 	Err   string `json:"error,omitempty"`
 	Errno int    `json:"errno,omitempty"`
 	Code  int    `json:"code"`
+
+	// The members of RFC 7807 problem details, see Problem.
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	ext *errorExt // a pointer, so that ErrorInfo values stay comparable
+}
+
+// errorExt holds the members of an ErrorInfo which aren't comparable.
+type errorExt struct {
+	extensions map[string]interface{} // see WithExtension
+	entry      *CatalogEntry          // see Catalog
+	args       []interface{}
 }
 
 // NewError creates a rpc error.
@@ -47,39 +66,146 @@ func (r *ErrorInfo) StatusCode() int {
 	return r.Code
 }
 
+// Expose returns true: the message of an ErrorInfo is written for clients,
+// so it isn't redacted, see RedactInternalErrors.
+func (r *ErrorInfo) Expose() bool {
+
+	return true
+}
+
 // ---------------------------------------------------------------------------
 
 type nestedObjectGetter interface {
 	NestedObject() interface{}
 }
 
-// GetErrorInfo returns http status code and an error message.
+// StatusCoder is implemented by errors carrying a http status code. An error
+// may also implement `Errno() int` to carry an errno.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+type errnoGetter interface {
+	Errno() int
+}
+
+// Exposer is implemented by errors telling whether their messages may be
+// replied to clients, see RedactInternalErrors.
+type Exposer interface {
+	Expose() bool
+}
+
+// RedactInternalErrors, if true, replaces the message of an error replied as
+// 5xx with "internal server error", so internal details (eg. file paths or
+// addresses) are not leaked to clients. Messages of *ErrorInfo errors, of
+// errors registered with a message (see RegisterError), and of errors whose
+// Expose method returns true are not redacted.
+var RedactInternalErrors bool
+
+const redactedMessage = "internal server error"
+
+func exposed(err error) bool {
+
+	e, ok := err.(Exposer)
+	return ok && e.Expose()
+}
+
+type errorMapping struct {
+	target error
+	code   int
+	errno  int
+	msg    string
+}
+
+var (
+	errorMappingMutex sync.RWMutex
+	errorMappings     = []*errorMapping{
+		{syscall.EINVAL, 400, 0, "invalid arguments"},
+		{os.ErrNotExist, 404, 0, "entry not found"}, // including syscall.ENOENT
+		{os.ErrExist, 409, 0, "entry already exists"},
+		{context.DeadlineExceeded, 504, 0, "deadline exceeded"},
+		{context.Canceled, 499, 0, "request canceled"},
+	}
+)
+
+// RegisterError maps errors matching target (see errors.Is) to a http status
+// code, an errno and a message (the message of the error if msg is empty),
+// eg. RegisterError(sql.ErrNoRows, 404, 0, "no such record"). Errors
+// registered later take precedence.
+func RegisterError(target error, code, errno int, msg string) {
+
+	errorMappingMutex.Lock()
+	defer errorMappingMutex.Unlock()
+	errorMappings = append([]*errorMapping{{target, code, errno, msg}}, errorMappings...)
+}
+
+// errorInfoOf finds an *ErrorInfo in the chain of err (see errors.Unwrap).
+func errorInfoOf(err error) *ErrorInfo {
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*ErrorInfo); ok {
+			return e
+		}
+		if getter, ok := err.(nestedObjectGetter); ok {
+			if e, ok := getter.NestedObject().(*ErrorInfo); ok {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// GetErrorInfo returns http status code and an error message. It looks in
+// the chain of err (see errors.Unwrap) for an *ErrorInfo or a StatusCoder,
+// then for errors registered by RegisterError; other errors are 500. The
+// message of a 5xx error may be redacted, see RedactInternalErrors.
 func GetErrorInfo(err error) (code, errno int, errmsg string) {
 
-	if e, ok := err.(*ErrorInfo); ok {
-		return e.Code, e.Errno, e.Error()
+	code, errno, errmsg, expose := getErrorInfo(err)
+	if code >= 500 && !expose && RedactInternalErrors {
+		errmsg = redactedMessage
 	}
-	if getter, ok := err.(nestedObjectGetter); ok {
-		if e, ok := getter.NestedObject().(*ErrorInfo); ok {
-			return e.Code, e.Errno, e.Error()
+	return
+}
+
+func getErrorInfo(err error) (code, errno int, errmsg string, expose bool) {
+
+	for err1 := err; err1 != nil; err1 = errors.Unwrap(err1) {
+		if e, ok := err1.(*ErrorInfo); ok {
+			return e.Code, e.Errno, e.Error(), true
+		}
+		if getter, ok := err1.(nestedObjectGetter); ok {
+			if e, ok := getter.NestedObject().(*ErrorInfo); ok {
+				return e.Code, e.Errno, e.Error(), true
+			}
+		}
+		if e, ok := err1.(StatusCoder); ok {
+			if e2, ok := err1.(errnoGetter); ok {
+				errno = e2.Errno()
+			}
+			return e.StatusCode(), errno, err1.Error(), exposed(err1)
 		}
 	}
-	switch err {
-	case syscall.EINVAL:
-		return 400, 0, "invalid arguments"
-	case syscall.ENOENT: // no such entry
-		return 404, 0, "entry not found"
-	case syscall.EEXIST: // entry exists
-		return 409, 0, "entry already exists"
+
+	errorMappingMutex.RLock()
+	defer errorMappingMutex.RUnlock()
+	for _, m := range errorMappings {
+		if errors.Is(err, m.target) {
+			if m.msg != "" {
+				return m.code, m.errno, m.msg, true
+			}
+			return m.code, m.errno, err.Error(), exposed(err)
+		}
 	}
-	return 500, 0, err.Error()
+	return 500, 0, err.Error(), exposed(err)
 }
 
 // ---------------------------------------------------------------------------
 
 type errorRet struct {
-	Err   string `json:"error"`
-	Errno int    `json:"errno,omitempty"`
+	XMLName xml.Name `json:"-" xml:"error"`
+	Err     string   `json:"error" xml:"message"`
+	Errno   int      `json:"errno,omitempty" xml:"errno,omitempty"`
 }
 
 // Error replies an error as a http response.
@@ -94,7 +220,7 @@ func Error(w http.ResponseWriter, err error) {
 		return
 	}
 
-	code, errno, errmsg := GetErrorInfo(err)
+	code, errno, errmsg := GetErrorInfo(localize(err, languageOf(w)))
 	Reply(w, code, &errorRet{Err: errmsg, Errno: errno})
 }
 
@@ -133,7 +259,8 @@ func ReplyWith(w http.ResponseWriter, code int, bodyType string, msg []byte) {
 	w.Write(msg)
 }
 
-// ReplyWithStream replies a http response.
+// ReplyWithStream replies a http response. See ServeContent to support Range
+// and conditional requests.
 func ReplyWithStream(w http.ResponseWriter, code int, bodyType string, body io.Reader, bytes int64) {
 
 	h := w.Header()
@@ -146,6 +273,10 @@ func ReplyWithStream(w http.ResponseWriter, code int, bodyType string, body io.R
 // ReplyWithCode replies a http response.
 func ReplyWithCode(w http.ResponseWriter, code int) {
 
+	if !BodyAllowed(code) {
+		w.WriteHeader(code)
+		return
+	}
 	if code < 400 {
 		h := w.Header()
 		h.Set("Content-Length", "2")
@@ -163,4 +294,11 @@ func ReplyWithCode(w http.ResponseWriter, code int) {
 
 var emptyObj = []byte{'{', '}'}
 
+// BodyAllowed reports whether a response of the status code may have a body.
+// 1xx, 204 (No Content) and 304 (Not Modified) responses don't.
+func BodyAllowed(code int) bool {
+
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
 // ---------------------------------------------------------------------------
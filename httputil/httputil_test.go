@@ -0,0 +1,116 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+
+type quotaError struct{}
+
+func (quotaError) Error() string   { return "quota exceeded" }
+func (quotaError) StatusCode() int { return 429 }
+func (quotaError) Errno() int      { return 1001 }
+
+type backendError struct{ expose bool }
+
+func (backendError) Error() string   { return "backend 10.0.0.2:9000 down" }
+func (backendError) StatusCode() int { return 503 }
+func (e backendError) Expose() bool  { return e.expose }
+
+type nested struct{ err *ErrorInfo }
+
+func (e nested) Error() string             { return "nested" }
+func (e nested) NestedObject() interface{} { return e.err }
+
+var errNoRows = errors.New("no rows")
+
+func TestGetErrorInfo(t *testing.T) {
+
+	RegisterError(errNoRows, 404, 612, "no such record")
+	_, errNotExist := os.Open("/no/such/file")
+
+	cases := []struct {
+		err   error
+		code  int
+		errno int
+		msg   string
+	}{
+		{NewErrorEx(403, 1, "forbidden"), 403, 1, "forbidden"},
+		{fmt.Errorf("get foo: %w", NewError(404, "no such foo")), 404, 0, "no such foo"},
+		{fmt.Errorf("get foo: %w", nested{NewError(409, "conflict")}), 409, 0, "conflict"},
+		{fmt.Errorf("put foo: %w", quotaError{}), 429, 1001, "quota exceeded"},
+		{syscall.EINVAL, 400, 0, "invalid arguments"},
+		{syscall.ENOENT, 404, 0, "entry not found"},
+		{errNotExist, 404, 0, "entry not found"},
+		{fmt.Errorf("open: %w", syscall.EEXIST), 409, 0, "entry already exists"},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), 504, 0, "deadline exceeded"},
+		{fmt.Errorf("query: %w", errNoRows), 404, 612, "no such record"},
+		{errors.New("dial tcp 10.0.0.1:3306: refused"), 500, 0, "dial tcp 10.0.0.1:3306: refused"},
+		{os.ErrPermission, 500, 0, "permission denied"}, // not mapped by default
+		{io.ErrUnexpectedEOF, 500, 0, "unexpected EOF"},
+	}
+	for _, c := range cases {
+		code, errno, msg := GetErrorInfo(c.err)
+		if code != c.code || errno != c.errno || msg != c.msg {
+			t.Fatal("GetErrorInfo:", c.err, code, errno, msg)
+		}
+	}
+
+	errShard := errors.New("shard 3 of 10.0.0.3 lost")
+	errQuota := errors.New("quota of 10.0.0.4 full")
+	RegisterError(errShard, 502, 0, "")
+	RegisterError(errQuota, 507, 0, "insufficient storage")
+
+	RedactInternalErrors = true
+	defer func() { RedactInternalErrors = false }()
+	redacts := []struct {
+		err  error
+		code int
+		msg  string
+	}{
+		{errors.New("dial tcp 10.0.0.1:3306: refused"), 500, "internal server error"},
+		{NewError(503, "backend busy"), 503, "backend busy"},
+		{fmt.Errorf("call: %w", backendError{}), 503, "internal server error"},
+		{fmt.Errorf("call: %w", backendError{expose: true}), 503, "backend 10.0.0.2:9000 down"},
+		{fmt.Errorf("read: %w", errShard), 502, "internal server error"},
+		{fmt.Errorf("write: %w", errQuota), 507, "insufficient storage"},
+		{fmt.Errorf("put foo: %w", quotaError{}), 429, "quota exceeded"},
+	}
+	for _, c := range redacts {
+		if code, _, msg := GetErrorInfo(c.err); code != c.code || msg != c.msg {
+			t.Fatal("GetErrorInfo with RedactInternalErrors:", c.err, code, msg)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+func TestErrorInfoComparable(t *testing.T) {
+
+	// ErrorInfo values are comparable, and can be map keys
+	counts := map[ErrorInfo]int{*NewError(404, "no such foo"): 1}
+	if *NewError(404, "no such foo") != *NewErrorEx(404, 0, "no such foo") || counts[*NewError(404, "no such foo")] != 1 {
+		t.Fatal("ErrorInfo values not equal")
+	}
+
+	e := NewError(403, "no credit").WithExtension("balance", 30)
+	e1 := e.WithExtension("cost", 50)
+	if len(e.Extensions()) != 1 || len(e1.Extensions()) != 2 || e1.Extensions()["balance"] != 30 {
+		t.Fatal("Extensions:", e.Extensions(), e1.Extensions())
+	}
+
+	// the sentinel isn't changed
+	e2 := e.WithType("https://example.com/probs/out-of-credit", "").WithInstance("/account/1")
+	if e.Type != "" || e.Instance != "" || e2.Type == "" || e2.Instance != "/account/1" || e2.Extensions()["balance"] != 30 {
+		t.Fatal("WithType/WithInstance:", *e, *e2)
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,210 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+/* ---------------------------------------------------------------------------
+
+Problem 按 RFC 7807 以 application/problem+json 的格式回复错误：
+
+	{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "You do not have enough credit.",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}
+
+其中 detail 为 ErrorInfo.Error()，errno 不为 0 时作为扩展成员 "errno" 输出。ErrorInfo 的 Type、Title、
+Instance 与 WithExtension 添加的扩展成员用于填充相应的成员：
+
+	err := httputil.NewError(403, "Your current balance is 30, but that costs 50.").
+		WithType("https://example.com/probs/out-of-credit", "You do not have enough credit.").
+		WithExtension("balance", 30)
+
+WithType、WithInstance 与 WithExtension 返回修改后的副本而不修改 err 本身，因此可以用于包级别的错误：
+
+	var ErrNoCredit = httputil.NewError(403, "You do not have enough credit.")
+
+	return ErrNoCredit.WithInstance(req.URL.Path)
+
+Error 仍然回复 {"error": ..., "errno": ...}。选择 Problem 的方式有：
+
+- rpcutil.ProblemRepl：总是以 Problem 回复错误；
+- rpcutil.NegotiateRepl：请求的 Accept 中包含 application/problem+json 时以 Problem 回复错误；
+- 直接调用 ErrorFor(w, req, err)：同上。
+
+ResponseError 能解析这两种格式的错误。
+
+// -------------------------------------------------------------------------*/
+
+// ProblemMediaType is the media type of RFC 7807 problem details.
+const ProblemMediaType = "application/problem+json"
+
+// WithType returns a copy of an error with the problem type URI and its
+// title set, see Problem. The error itself isn't changed, so package-level
+// errors may be specialized safely.
+func (r *ErrorInfo) WithType(typ, title string) *ErrorInfo {
+
+	e := *r
+	e.Type, e.Title = typ, title
+	return &e
+}
+
+// WithInstance returns a copy of an error with the URI identifying the
+// occurrence of it set, see Problem.
+func (r *ErrorInfo) WithInstance(instance string) *ErrorInfo {
+
+	e := *r
+	e.Instance = instance
+	return &e
+}
+
+// WithExtension returns a copy of an error with an extension member added,
+// see Problem.
+func (r *ErrorInfo) WithExtension(key string, value interface{}) *ErrorInfo {
+
+	e := *r
+	e.ext = &errorExt{extensions: make(map[string]interface{}, 1)}
+	if r.ext != nil { // copied, as copies of r share r.ext
+		e.ext.entry, e.ext.args = r.ext.entry, r.ext.args
+		for k, v := range r.ext.extensions {
+			e.ext.extensions[k] = v
+		}
+	}
+	e.ext.extensions[key] = value
+	return &e
+}
+
+// Extensions returns the extension members of an error added by
+// WithExtension, or nil if none. The map must not be modified.
+func (r *ErrorInfo) Extensions() map[string]interface{} {
+
+	if r.ext == nil {
+		return nil
+	}
+	return r.ext.extensions
+}
+
+// problemOf returns the RFC 7807 problem details of an error.
+func problemOf(err error) (code int, problem map[string]interface{}) {
+
+	code, errno, errmsg := GetErrorInfo(err)
+	problem = make(map[string]interface{})
+	typ, title := "about:blank", http.StatusText(code)
+	if e := errorInfoOf(err); e != nil {
+		for k, v := range e.Extensions() {
+			problem[k] = v
+		}
+		if e.Type != "" {
+			typ = e.Type
+		}
+		if e.Title != "" {
+			title = e.Title
+		}
+		if e.Instance != "" {
+			problem["instance"] = e.Instance
+		}
+	}
+	if errno != 0 {
+		problem["errno"] = errno
+	}
+	problem["type"] = typ
+	if title != "" {
+		problem["title"] = title
+	}
+	problem["status"] = code
+	problem["detail"] = errmsg
+	return
+}
+
+// Problem replies an error as RFC 7807 problem details in
+// application/problem+json.
+func Problem(w http.ResponseWriter, err error) {
+
+	if err == nil {
+		Error(w, nil)
+		return
+	}
+
+	code, problem := problemOf(localize(err, languageOf(w)))
+	msg, err2 := json.Marshal(problem)
+	if err2 != nil {
+		Error(w, err)
+		return
+	}
+	ReplyWith(w, code, ProblemMediaType, msg)
+}
+
+// AcceptsProblem reports whether the Accept header of req lists
+// application/problem+json.
+func AcceptsProblem(req *http.Request) bool {
+
+	for _, r := range parseAccept(req.Header.Get("Accept")) {
+		if r.typ == ProblemMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorFor replies an error by Problem if req accepts
+// application/problem+json, and by Error otherwise. The message of an error
+// in a Catalog is localized, see Localize.
+func ErrorFor(w http.ResponseWriter, req *http.Request, err error) {
+
+	err = Localize(err, req)
+	if AcceptsProblem(req) {
+		Problem(w, err)
+	} else {
+		Error(w, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// decodeProblem decodes RFC 7807 problem details into an *ErrorInfo.
+func decodeProblem(e *ErrorInfo, b []byte) bool {
+
+	var problem map[string]json.RawMessage
+	if json.Unmarshal(b, &problem) != nil {
+		return false
+	}
+	for k, v := range problem {
+		var err error
+		switch k {
+		case "type":
+			err = json.Unmarshal(v, &e.Type)
+		case "title":
+			err = json.Unmarshal(v, &e.Title)
+		case "status":
+			err = json.Unmarshal(v, &e.Code)
+		case "detail":
+			err = json.Unmarshal(v, &e.Err)
+		case "instance":
+			err = json.Unmarshal(v, &e.Instance)
+		case "errno":
+			err = json.Unmarshal(v, &e.Errno)
+		default:
+			var ext interface{}
+			if err = json.Unmarshal(v, &ext); err == nil {
+				*e = *e.WithExtension(k, ext)
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+	if e.Err == "" {
+		e.Err = e.Title
+	}
+	if e.Type == "about:blank" { // the title is the status text
+		e.Type, e.Title = "", ""
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,185 @@
+package httputil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// ---------------------------------------------------------------------------
+
+type routeKey struct{}
+
+// WithRoute returns a shallow copy of req which records the route pattern
+// req is dispatched by.
+func WithRoute(req *http.Request, pattern string) *http.Request {
+
+	return req.WithContext(context.WithValue(req.Context(), routeKey{}, pattern))
+}
+
+// RouteOf returns the route pattern recorded by WithRoute, or "" if none.
+func RouteOf(req *http.Request) string {
+
+	pattern, _ := req.Context().Value(routeKey{}).(string)
+	return pattern
+}
+
+// ---------------------------------------------------------------------------
+
+// ErrPanic is the error replied when a handler method panics.
+var ErrPanic = NewError(http.StatusInternalServerError, "internal server error")
+
+// PanicInfo describes a panic recovered from a handler method.
+type PanicInfo struct {
+	Method string      // name of the handler method
+	Route  string      // route pattern, see RouteOf
+	Value  interface{} // value passed to panic
+	Stack  []byte
+}
+
+// OnPanic handles a panic recovered from a handler method: it logs the panic,
+// calls hook (if not nil) and replies ErrPanic by calling reply. If w is
+// tracked by TrackResponse and the response has started, nothing can be
+// replied, so it panics with http.ErrAbortHandler to abort the response.
+func OnPanic(
+	w http.ResponseWriter, req *http.Request, info *PanicInfo,
+	reply func(w http.ResponseWriter, err error), hook func(req *http.Request, info *PanicInfo)) {
+
+	log.Printf("panic: %v [method: %s, route: %s]\n%s", info.Value, info.Method, info.Route, info.Stack)
+	if hook != nil {
+		hook(req, info)
+	}
+	if ResponseStarted(w) {
+		panic(http.ErrAbortHandler)
+	}
+	reply(w, ErrPanic)
+}
+
+// ---------------------------------------------------------------------------
+
+// TrackResponse returns a http.ResponseWriter which writes to w and records
+// whether the response has started, see ResponseStarted. It forwards Flush,
+// Hijack, ReadFrom, Push and CloseNotify to w, and can be unwrapped by
+// http.ResponseController.
+func TrackResponse(w http.ResponseWriter) http.ResponseWriter {
+
+	if _, ok := w.(*trackWriter); ok {
+		return w
+	}
+	return &trackWriter{ResponseWriter: w}
+}
+
+// ResponseStarted reports whether the header (or a part of the body) of the
+// response written to w has been sent, or the connection has been hijacked.
+// w is a writer returned by TrackResponse, or a writer wrapping it with an
+// Unwrap method; it returns false if w isn't tracked.
+func ResponseStarted(w http.ResponseWriter) bool {
+
+	for {
+		switch v := w.(type) {
+		case *trackWriter:
+			if v.started {
+				return true
+			}
+			w = v.ResponseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+var errNotHijacker = errors.New("http.ResponseWriter isn't a http.Hijacker")
+
+type trackWriter struct {
+	http.ResponseWriter
+	started bool
+	lang    string // see WithLanguage
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *trackWriter) Unwrap() http.ResponseWriter {
+
+	return w.ResponseWriter
+}
+
+func (w *trackWriter) WriteHeader(code int) {
+
+	if code >= 200 || code == http.StatusSwitchingProtocols { // not 1xx informational responses
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackWriter) Write(b []byte) (int, error) {
+
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered data to the client, see http.Flusher.
+func (w *trackWriter) Flush() {
+
+	w.started = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, see http.Hijacker. It unwraps the
+// underlying writers to find a http.Hijacker.
+func (w *trackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	for w1 := w.ResponseWriter; ; {
+		if h, ok := w1.(http.Hijacker); ok {
+			conn, brw, err := h.Hijack()
+			if err == nil {
+				w.started = true
+			}
+			return conn, brw, err
+		}
+		u, ok := w1.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, nil, errNotHijacker
+		}
+		w1 = u.Unwrap()
+	}
+}
+
+// ReadFrom copies the data of r to the response, see io.ReaderFrom. It lets
+// io.Copy use the ReadFrom (eg. sendfile) of the underlying writer.
+func (w *trackWriter) ReadFrom(r io.Reader) (int64, error) {
+
+	w.started = true
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Push initiates an HTTP/2 server push, see http.Pusher. It returns
+// http.ErrNotSupported if the underlying writer isn't a http.Pusher.
+func (w *trackWriter) Push(target string, opts *http.PushOptions) error {
+
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// CloseNotify forwards to the underlying http.CloseNotifier. If the
+// underlying writer isn't one, the channel returned never receives.
+func (w *trackWriter) CloseNotify() <-chan bool {
+
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,81 @@
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+
+type wrapWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestResponseStarted(t *testing.T) {
+
+	cases := []struct {
+		write   func(w http.ResponseWriter)
+		started bool
+	}{
+		{func(w http.ResponseWriter) { w.Header().Set("X-A", "1") }, false},
+		{func(w http.ResponseWriter) { w.WriteHeader(http.StatusEarlyHints) }, false},
+		{func(w http.ResponseWriter) { w.WriteHeader(200) }, true},
+		{func(w http.ResponseWriter) { w.Write([]byte("a")) }, true},
+		{func(w http.ResponseWriter) { w.(http.Flusher).Flush() }, true},
+	}
+	for i, c := range cases {
+		w := TrackResponse(httptest.NewRecorder())
+		c.write(w)
+		if ResponseStarted(wrapWriter{w}) != c.started {
+			t.Fatal(i, "unexpected ResponseStarted:", !c.started)
+		}
+	}
+
+	if ResponseStarted(httptest.NewRecorder()) {
+		t.Fatal("ResponseStarted of an untracked writer")
+	}
+	if _, _, err := TrackResponse(httptest.NewRecorder()).(http.Hijacker).Hijack(); err != errNotHijacker {
+		t.Fatal("Hijack:", err)
+	}
+	if err := TrackResponse(httptest.NewRecorder()).(http.Pusher).Push("/a", nil); err != http.ErrNotSupported {
+		t.Fatal("Push:", err)
+	}
+}
+
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (w *readerFromWriter) ReadFrom(r io.Reader) (int64, error) {
+
+	w.n++
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestTrackReadFrom(t *testing.T) {
+
+	rw := &readerFromWriter{ResponseRecorder: httptest.NewRecorder()}
+	w := TrackResponse(rw)
+	r := io.LimitReader(strings.NewReader("hello"), 10) // not an io.WriterTo
+	if _, err := io.Copy(w, r); err != nil || rw.n != 1 || rw.Body.String() != "hello" {
+		t.Fatal("io.Copy:", rw.n, rw.Body.String(), err)
+	}
+	if !ResponseStarted(w) {
+		t.Fatal("ReadFrom doesn't start the response")
+	}
+
+	recorder := httptest.NewRecorder()
+	if _, err := io.Copy(TrackResponse(recorder), strings.NewReader("hello")); err != nil || recorder.Body.String() != "hello" {
+		t.Fatal("io.Copy:", recorder.Body.String(), err)
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,65 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ---------------------------------------------------------------------------
+
+// NDJSONMediaType is the media type of newline delimited JSON, a JSON value
+// per line.
+const NDJSONMediaType = "application/x-ndjson"
+
+// StreamErrorTrailer is the trailer carrying the error which ends a streamed
+// reply (eg. a list streamed by rpcutil) after its header was written. Its
+// value is {"code": ..., "error": ..., "errno": ...} in JSON.
+const StreamErrorTrailer = "X-Stream-Error"
+
+type streamError struct {
+	Code  int    `json:"code"`
+	Err   string `json:"error"`
+	Errno int    `json:"errno,omitempty"`
+}
+
+// SetStreamError sets the StreamErrorTrailer of a streamed reply to err. The
+// trailer should be declared by the Trailer header before the header of the
+// reply is written.
+func SetStreamError(w http.ResponseWriter, err error) {
+
+	code, errno, errmsg := GetErrorInfo(err)
+	msg, _ := json.Marshal(&streamError{Code: code, Err: errmsg, Errno: errno})
+	w.Header().Set(StreamErrorTrailer, string(msg))
+}
+
+// StreamError returns the error set by SetStreamError in the trailer of resp
+// as an *ErrorInfo, or nil if none. The trailer is available after the body
+// of resp is read to EOF.
+func StreamError(resp *http.Response) error {
+
+	v := resp.Trailer.Get(StreamErrorTrailer)
+	if v == "" {
+		return nil
+	}
+	var e streamError
+	if err := json.Unmarshal([]byte(v), &e); err != nil {
+		return NewError(500, v)
+	}
+	return NewErrorEx(e.Code, e.Errno, e.Err)
+}
+
+// Preferred returns the one of mediaTypes most preferred by the Accept header
+// of req, or mediaTypes[0] if none of them is listed explicitly.
+func Preferred(req *http.Request, mediaTypes ...string) string {
+
+	for _, r := range parseAccept(req.Header.Get("Accept")) {
+		for _, mediaType := range mediaTypes {
+			if r.typ == mediaType {
+				return mediaType
+			}
+		}
+	}
+	return mediaTypes[0]
+}
+
+// ---------------------------------------------------------------------------
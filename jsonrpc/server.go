@@ -0,0 +1,452 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/qiniu/http/formutil"
+	"github.com/qiniu/http/hfac"
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/rpcutil"
+)
+
+/* ---------------------------------------------------------------------------
+
+jsonrpc 以 JSON-RPC 2.0 协议(https://www.jsonrpc.org/specification)在一个 http 地址上暴露服务对象
+的方法，服务对象与 restrpc 的相同，调用方不需要知道 REST 的路径：
+
+	server := new(jsonrpc.Server)
+	server.Register(service)             // 方法名如 "PostFoo_Bar"
+	server.RegisterName("foo", service2) // 方法名如 "foo.PostFoo_Bar"
+	mux.Handle("POST /rpc", server)
+
+和 restrpc 一样，只有以 Server.Prefixes(默认为 DefaultPrefixes，即 Do 与 restrpc 的 Get/Post/Put/
+Delete/Patch)之一为前缀(参见 hfac.PrefixOf)的方法才会暴露，服务对象的其他导出方法(如 Close、
+ResetCache)不能被远程调用。
+
+方法的规格与 rpcutil 相同(参见 rpcutil.HandlerCreator.Spec)，但必须返回 error：
+
+	func (rcvr *XXXX) YYYY([ctx context.Context, ][req *ZZZZ, ][env ENV]) ([ret RRRR, ]err error)
+
+- params 为对象时按 JSON 解码为 req，为数组时只能有一个元素(即 req)。解码后按 `validate` 标签检查
+  (参见 formutil.Validate)，失败时返回 -32602 错误。
+- ENV 为 rpcutil.Env 或实现了 OpenEnv/CloseEnv 的指针类型(如 *restrpc.Env)。每次调用都会
+  OpenEnv/CloseEnv，OpenEnv 的错误(如鉴权失败)作为这次调用的错误返回。
+- ret 编码为 JSON 作为 result，没有 ret 时 result 为 null。
+- 方法返回的错误按 httputil.GetErrorInfo 映射为 error 对象：code 为 ServerError(-32000)，message
+  为错误信息，data 为 ErrorData(http 状态码与 errno)。方法也可以直接返回 *Error。
+- 支持批量调用与通知(没有 id 的请求，不回复)。批量调用中的请求按顺序执行。如果没有需要回复的
+  结果(如都是通知)，回复 204。
+
+// -------------------------------------------------------------------------*/
+
+// Error codes, see the JSON-RPC 2.0 specification, section 5.1.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+	ServerError    = -32000 // errors returned by methods
+)
+
+// Error is the error object of a response.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+
+	return e.Message
+}
+
+// ErrorData is the data of a ServerError, see httputil.GetErrorInfo.
+type ErrorData struct {
+	Code  int `json:"code"` // http status code
+	Errno int `json:"errno,omitempty"`
+}
+
+// Request is a request object.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // nil for a notification
+}
+
+// Response is a response object.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// DefaultMaxBatchSize is the default limit of the number of requests in a
+// batch.
+const DefaultMaxBatchSize = 100
+
+// DefaultPrefixes are the default method name prefixes of methods exposed
+// by Server.
+var DefaultPrefixes = []string{"Do", "Get", "Post", "Put", "Delete", "Patch"}
+
+var (
+	errMustPOST      = httputil.NewError(http.StatusMethodNotAllowed, "Request method must be POST")
+	errInvalidParams = errors.New("params must be an object or an array of one element")
+	nullID           = json.RawMessage("null")
+)
+
+// ---------------------------------------------------------------------------
+
+type itfEnv interface {
+	OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error
+	CloseEnv()
+}
+
+type itfEnvContext interface {
+	Context() context.Context
+}
+
+var unusedError *error
+var typeOfError = reflect.TypeOf(unusedError).Elem()
+var typeOfEnv = reflect.TypeOf(rpcutil.Env{})
+
+type method struct {
+	rcvr reflect.Value
+	fn   reflect.Value
+	spec *rpcutil.MethodSpec
+}
+
+// Server is an http.Handler serving JSON-RPC 2.0 calls (by POST) of methods
+// of registered receivers. Methods should be registered before serving.
+type Server struct {
+	// Interceptors are run in order around every method call, see
+	// rpcutil.Interceptor. Invocation.Method is the name called.
+	Interceptors []rpcutil.Interceptor
+
+	// MaxBatchSize limits the number of requests in a batch;
+	// DefaultMaxBatchSize if 0.
+	MaxBatchSize int
+
+	// Prefixes are the method name prefixes (see hfac.PrefixOf) of methods
+	// registered; DefaultPrefixes if nil.
+	Prefixes []string
+
+	// OnPanic, if not nil, is called after a panic of a method is recovered
+	// and logged. The call fails with InternalError.
+	OnPanic func(req *http.Request, info *httputil.PanicInfo)
+
+	methods map[string]*method
+}
+
+// Register registers the methods of rcvr, named by their method names (eg.
+// "PostFoo_Bar"). Methods not returning an error, or whose names don't begin
+// with one of Prefixes, are skipped.
+func (s *Server) Register(rcvr interface{}) error {
+
+	return s.RegisterName("", rcvr)
+}
+
+// RegisterName registers the methods of rcvr, named by name + "." + their
+// method names (eg. "foo.PostFoo_Bar"), or their method names if name is "".
+// It returns a *formutil.TagError if the args of a method have a malformed
+// `validate` tag.
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+
+	if s.methods == nil {
+		s.methods = make(map[string]*method)
+	}
+	if name != "" {
+		name += "."
+	}
+
+	prefixes := s.Prefixes
+	if prefixes == nil {
+		prefixes = DefaultPrefixes
+	}
+
+	typ := reflect.TypeOf(rcvr)
+	rcvr1 := reflect.ValueOf(rcvr)
+	n := 0
+	for m := 0; m < typ.NumMethod(); m++ {
+		meth := typ.Method(m)
+		if !hasPrefix(meth.Name, prefixes) {
+			continue
+		}
+		mtype := meth.Type
+		if nout := mtype.NumOut(); nout == 0 || mtype.Out(nout-1) != typeOfError {
+			continue
+		}
+		spec, err := rpcutil.HandlerCreator{}.Spec(meth)
+		if err != nil {
+			continue
+		}
+		if spec.ReqType != nil {
+			if err = formutil.CheckTags(spec.ReqType); err != nil { // see decodeParams
+				return err
+			}
+		}
+		s.methods[name+meth.Name] = &method{rcvr: rcvr1, fn: meth.Func, spec: spec}
+		log.Println("Install jsonrpc method", name+meth.Name)
+		n++
+	}
+	if n == 0 {
+		log.Println("jsonrpc:", typ, "has no method to register")
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+func hasPrefix(name string, prefixes []string) bool {
+
+	prefix, ok := hfac.PrefixOf(name)
+	if !ok {
+		return false
+	}
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		httputil.Error(w, errMustPOST)
+		return
+	}
+	if err := httputil.DecodeRequest(req); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		if _, ok := err.(*httputil.ErrorInfo); !ok { // eg. httputil.ErrDecodedBodyTooLarge
+			err = httputil.NewError(400, err.Error())
+		}
+		httputil.Error(w, err)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if resp := s.handle(w, req, body); resp != nil {
+			httputil.Reply(w, 200, resp)
+		} else {
+			httputil.ReplyWithCode(w, 204)
+		}
+		return
+	}
+
+	var msgs []json.RawMessage
+	if err = json.Unmarshal(body, &msgs); err != nil {
+		httputil.Reply(w, 200, errorResponse(nil, &Error{Code: ParseError, Message: err.Error()}))
+		return
+	}
+	maxBatchSize := s.MaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	switch {
+	case len(msgs) == 0:
+		httputil.Reply(w, 200, errorResponse(nil, &Error{Code: InvalidRequest, Message: "empty batch"}))
+		return
+	case len(msgs) > maxBatchSize:
+		httputil.Reply(w, 200, errorResponse(nil, &Error{Code: InvalidRequest, Message: "too many requests in a batch"}))
+		return
+	}
+	resps := make([]*Response, 0, len(msgs))
+	for _, msg := range msgs {
+		if resp := s.handle(w, req, msg); resp != nil {
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) == 0 {
+		httputil.ReplyWithCode(w, 204)
+		return
+	}
+	httputil.Reply(w, 200, resps)
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+
+	if id == nil {
+		id = nullID
+	}
+	return &Response{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+// validID reports whether id is a string, a number or null.
+func validID(id json.RawMessage) bool {
+
+	switch c := id[0]; {
+	case c == '"' || c == '-' || (c >= '0' && c <= '9'):
+		return true
+	default:
+		return bytes.Equal(id, nullID)
+	}
+}
+
+// handle serves a request object, and returns nil for a notification.
+func (s *Server) handle(w http.ResponseWriter, req *http.Request, msg []byte) *Response {
+
+	var r Request
+	if err := json.Unmarshal(msg, &r); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok || len(msg) == 0 {
+			return errorResponse(nil, &Error{Code: ParseError, Message: "parse error"})
+		}
+		return errorResponse(nil, &Error{Code: InvalidRequest, Message: err.Error()})
+	}
+	if r.ID != nil && !validID(r.ID) {
+		return errorResponse(nil, &Error{Code: InvalidRequest, Message: "invalid id"})
+	}
+	if r.JSONRPC != "2.0" || r.Method == "" {
+		return errorResponse(r.ID, &Error{Code: InvalidRequest, Message: "invalid request"})
+	}
+
+	ret, err := s.call(w, req, &r)
+	if r.ID == nil { // notification
+		return nil
+	}
+	if err != nil {
+		return errorResponse(r.ID, errorOf(err, req))
+	}
+	result, err := json.Marshal(ret)
+	if err != nil {
+		return errorResponse(r.ID, &Error{Code: InternalError, Message: err.Error()})
+	}
+	return &Response{JSONRPC: "2.0", Result: result, ID: r.ID}
+}
+
+// errorOf maps err to an error object, see httputil.GetErrorInfo.
+func errorOf(err error, req *http.Request) *Error {
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	code, errno, msg := httputil.GetErrorInfo(httputil.Localize(err, req))
+	return &Error{Code: ServerError, Message: msg, Data: &ErrorData{Code: code, Errno: errno}}
+}
+
+func (s *Server) call(w http.ResponseWriter, req *http.Request, r *Request) (ret interface{}, err error) {
+
+	m, ok := s.methods[r.Method]
+	if !ok {
+		return nil, &Error{Code: MethodNotFound, Message: "method not found: " + r.Method}
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			info := &httputil.PanicInfo{
+				Method: r.Method, Route: httputil.RouteOf(req), Value: v, Stack: debug.Stack(),
+			}
+			httputil.OnPanic(w, req, info, func(w http.ResponseWriter, e error) {
+				ret, err = nil, &Error{Code: InternalError, Message: e.Error()}
+			}, s.OnPanic)
+		}
+	}()
+
+	inv := &rpcutil.Invocation{Method: r.Method, Ctx: req.Context(), W: w, Req: req}
+	switch spec := m.spec; {
+	case spec.EnvType == typeOfEnv:
+		inv.Env = rpcutil.Env{W: w, Req: req}
+	case spec.EnvType != nil:
+		env := reflect.New(spec.EnvType.Elem()).Interface().(itfEnv)
+		w1 := w
+		if err = env.OpenEnv(m.rcvr.Interface(), &w1, req); err != nil {
+			return
+		}
+		defer env.CloseEnv()
+		if envCtx, ok := env.(itfEnvContext); ok {
+			if ctx1 := envCtx.Context(); ctx1 != nil {
+				inv.Ctx = ctx1
+			}
+		}
+		inv.Env = env
+	}
+
+	if m.spec.ReqType != nil {
+		args := reflect.New(m.spec.ReqType)
+		if err = decodeParams(args, r.Params); err != nil {
+			return nil, &Error{Code: InvalidParams, Message: err.Error()}
+		}
+		inv.Args = args.Interface()
+	}
+
+	return rpcutil.Chain(s.Interceptors, m.call)(inv)
+}
+
+// decodeParams decodes params by name (an object) or by position (an array of
+// one element) into args, and validates it.
+func decodeParams(args reflect.Value, params json.RawMessage) error {
+
+	switch {
+	case len(params) == 0 || bytes.Equal(params, nullID):
+	case params[0] == '{':
+		if err := json.Unmarshal(params, args.Interface()); err != nil {
+			return err
+		}
+	case params[0] == '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(params, &items); err != nil {
+			return err
+		}
+		if len(items) != 1 {
+			return errInvalidParams
+		}
+		if err := json.Unmarshal(items[0], args.Interface()); err != nil {
+			return err
+		}
+	default:
+		return errInvalidParams
+	}
+	if args.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return formutil.ValidateValue(args, "json")
+}
+
+func (m *method) call(inv *rpcutil.Invocation) (ret interface{}, err error) {
+
+	args := make([]reflect.Value, 0, 4)
+	args = append(args, m.rcvr)
+	if m.spec.HasCtx {
+		args = append(args, reflect.ValueOf(inv.Ctx))
+	}
+	if m.spec.ReqType != nil {
+		args = append(args, reflect.ValueOf(inv.Args))
+	}
+	if m.spec.EnvType != nil {
+		args = append(args, reflect.ValueOf(inv.Env))
+	}
+
+	out := m.fn.Call(args)
+	if err1 := out[len(out)-1]; !err1.IsNil() {
+		err = err1.Interface().(error)
+	}
+	if len(out) == 2 {
+		ret = out[0].Interface()
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,198 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/http/formutil"
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/jsonrpc"
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+)
+
+// ---------------------------------------------------------------------------
+
+type userKey struct{}
+
+type authEnv struct {
+	restrpc.Env
+}
+
+var closedEnvs int
+
+func (p *authEnv) OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error {
+	if req.Header.Get("Authorization") != "token" {
+		return httputil.NewError(401, "bad token")
+	}
+	p.Env.OpenEnv(rcvr, w, req)
+	p.Ctx = context.WithValue(p.Ctx, userKey{}, "user1")
+	return nil
+}
+
+func (p *authEnv) CloseEnv() {
+	closedEnvs++
+}
+
+type addArgs struct {
+	A int `json:"a"`
+	B int `json:"b" validate:"min=0"`
+}
+
+type service struct {
+	notified []string
+}
+
+func (p *service) DoAdd(args *addArgs) (ret int, err error) {
+	return args.A + args.B, nil
+}
+
+func (p *service) GetWhoami(ctx context.Context, env *authEnv) (ret string, err error) {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user, nil
+}
+
+func (p *service) DoNotify(args *struct{ Msg string }) error {
+	p.notified = append(p.notified, args.Msg)
+	return nil
+}
+
+func (p *service) DoFail(env rpcutil.Env) error {
+	return httputil.NewErrorEx(404, 612, "no such entry")
+}
+
+func (p *service) DoPanic() error {
+	panic("oops")
+}
+
+func (p *service) DoRaw(w http.ResponseWriter, req *http.Request) { // not registered
+}
+
+func (p *service) Close() error { // not registered: no method prefix
+	panic("Close called")
+}
+
+func (p *service) ResetAll() error { // not registered: not a prefix of Server.Prefixes
+	panic("ResetAll called")
+}
+
+func TestServer(t *testing.T) {
+
+	svc := new(service)
+	server := &jsonrpc.Server{MaxBatchSize: 3}
+	if err := server.Register(svc); err != nil {
+		t.Fatal("Register failed:", err)
+	}
+	server.RegisterName("svc", svc)
+	var called []string
+	server.Interceptors = []rpcutil.Interceptor{
+		func(inv *rpcutil.Invocation, next rpcutil.Invoker) (interface{}, error) {
+			called = append(called, inv.Method)
+			return next(inv)
+		},
+	}
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+
+	cases := []struct {
+		auth string
+		req  string
+		code int
+		resp string
+	}{
+		{"", `{"jsonrpc":"2.0","method":"DoAdd","params":{"a":1,"b":2},"id":1}`, 200,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"", `{"jsonrpc":"2.0","method":"svc.DoAdd","params":[{"a":3,"b":4}],"id":"x"}`, 200,
+			`{"jsonrpc":"2.0","result":7,"id":"x"}`},
+		{"", `{"jsonrpc":"2.0","method":"DoAdd","params":{"a":1,"b":-1},"id":null}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid arguments: b: min=0"},"id":null}`},
+		{"", `{"jsonrpc":"2.0","method":"DoAdd","params":[1,2],"id":2}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"params must be an object or an array of one element"},"id":2}`},
+		{"", `{"jsonrpc":"2.0","method":"GetWhoami","id":3}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"bad token","data":{"code":401}},"id":3}`},
+		{"token", `{"jsonrpc":"2.0","method":"GetWhoami","id":3}`, 200,
+			`{"jsonrpc":"2.0","result":"user1","id":3}`},
+		{"", `{"jsonrpc":"2.0","method":"DoFail","id":4}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"no such entry","data":{"code":404,"errno":612}},"id":4}`},
+		{"", `{"jsonrpc":"2.0","method":"DoPanic","id":5}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal server error"},"id":5}`},
+		{"", `{"jsonrpc":"2.0","method":"DoRaw","id":6}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: DoRaw"},"id":6}`},
+		{"", `{"jsonrpc":"2.0","method":"Close","id":6}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: Close"},"id":6}`},
+		{"", `{"jsonrpc":"2.0","method":"svc.ResetAll","id":6}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: svc.ResetAll"},"id":6}`},
+		{"", `{"jsonrpc":"2.0","method":"DoNotify","params":{"Msg":"a"}}`, 204, ``},
+		{"", `{"jsonrpc":"2.0","method":"DoAdd","id":{}}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid id"},"id":null}`},
+		{"", `{"jsonrpc":"1.0","method":"DoAdd","id":7}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":7}`},
+		{"", `{"jsonrpc":"2.0","method"`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`},
+		{"", `[]`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`},
+		{"", `[1,2,3,4]`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"too many requests in a batch"},"id":null}`},
+		{"", `[1, {"jsonrpc":"2.0","method":"DoAdd","params":{"a":1},"id":8}, {"jsonrpc":"2.0","method":"DoNotify","params":{"Msg":"b"}}]`, 200,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type jsonrpc.Request"},"id":null},` +
+				`{"jsonrpc":"2.0","result":1,"id":8}]`},
+		{"", `[{"jsonrpc":"2.0","method":"DoNotify","params":{"Msg":"c"}}]`, 204, ``},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", svr.URL, strings.NewReader(c.req))
+		req.Header.Set("Content-Type", "application/json")
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(i, "request failed:", err)
+		}
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		resp.Body.Close()
+		if resp.StatusCode != c.code || string(raw) != c.resp {
+			t.Fatal(i, "unexpected resp:", resp.StatusCode, string(raw), "expected:", c.resp)
+		}
+	}
+
+	if strings.Join(svc.notified, ",") != "a,b,c" {
+		t.Fatal("unexpected notifications:", svc.notified)
+	}
+	if closedEnvs != 1 { // OpenEnv failed in the first call of GetWhoami
+		t.Fatal("unexpected CloseEnv calls:", closedEnvs)
+	}
+	if called[0] != "DoAdd" || called[1] != "svc.DoAdd" {
+		t.Fatal("unexpected interceptor calls:", called)
+	}
+
+	resp, err := http.Get(svr.URL)
+	if err != nil || resp.StatusCode != 405 {
+		t.Fatal("GET:", resp, err)
+	}
+	resp.Body.Close()
+}
+
+type badTagService struct{}
+
+func (p *badTagService) DoAdd(args *struct {
+	A int `json:"a" validate:"min=a"`
+}) (ret int, err error) {
+	return args.A, nil
+}
+
+func TestRegisterBadTag(t *testing.T) {
+
+	server := new(jsonrpc.Server)
+	if err := server.Register(new(badTagService)); err == nil {
+		t.Fatal("malformed validate tags not found by Register")
+	} else if _, ok := err.(*formutil.TagError); !ok {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
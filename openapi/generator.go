@@ -0,0 +1,453 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+)
+
+/* ---------------------------------------------------------------------------
+
+Generator 按照 restrpc.Router.Register 的方式遍历一个 receiver，生成 OpenAPI 3 文档：
+
+	router := &restrpc.Router{PatternPrefix: "/v1"}
+	mux := router.Register(service)
+
+	g := openapi.NewGenerator(openapi.Info{Title: "foo", Version: "1.0"})
+	g.Register(router, service)
+	openapi.Serve(mux, "/openapi.json", g.Document())
+
+路径参数：匿名的 * 按顺序命名为 arg1, arg2, ...(与 Env.Args 的下标对应)，命名参数使用其名字。
+请求参数：GET/DELETE/HEAD/OPTIONS 请求的 args 字段作为 query 参数，其余请求的 args 作为 JSON 或
+form body。带 `path` 标签的字段是路径参数，带 ReqBody 字段的 args 以 ReqBody 作为 body。
+返回值：ret 作为成功响应的 JSON body(rpcutil.Blob、rpcutil.Stream、httputil.Content 与 io.Reader 作为二进制 body，
+<-chan rpcutil.Event 作为 text/event-stream，rpcutil.ListIterator 与其他 channel 作为 JSON 数组或
+NDJSON)，错误的 body 为 {"error": ..., "errno": ...}。
+
+成功响应的状态码与 rpcutil 一致：缺省为 200，由 Creator.StatusCodes 按方法名或前缀指定，所以 Creator
+应与 Router.Factory 所用的 HandlerCreator 相同。ret 是 rpcutil.Response 或实现了 StatusCode() 时，
+状态码由返回值决定，记作 2XX：
+
+	creator := rpcutil.HandlerCreator{StatusCodes: map[string]int{"Post": 201, "Delete": 204}}
+	router := &restrpc.Router{Factory: restrpc.NewFactory(creator)}
+
+	g := openapi.NewGenerator(openapi.Info{Title: "foo", Version: "1.0"})
+	g.Creator = creator
+
+// -------------------------------------------------------------------------*/
+
+// Generator generates an OpenAPI document from receivers of restrpc.Router.
+type Generator struct {
+	// Creator is the creator of the handlers of the routes, whose StatusCodes
+	// key the successful responses, see restrpc.NewFactory.
+	Creator rpcutil.HandlerCreator
+
+	doc   *Document
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+}
+
+// NewGenerator creates a Generator.
+func NewGenerator(info Info) *Generator {
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: &Components{
+			Schemas: map[string]*Schema{"Error": errorSchema},
+		},
+	}
+	return &Generator{
+		doc:   doc,
+		names: make(map[reflect.Type]string),
+		types: map[string]reflect.Type{"Error": nil},
+	}
+}
+
+// Document returns the generated document.
+func (p *Generator) Document() *Document {
+
+	return p.doc
+}
+
+// Register adds the routes of rcvr, the same as router.Register(rcvr, routes...) installs.
+func (p *Generator) Register(router *restrpc.Router, rcvr interface{}, routes ...[][2]string) {
+
+	for _, route := range router.Routes(rcvr, routes...) {
+		p.addRoute(route)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+var errorSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"error": {Type: "string"},
+		"errno": {Type: "integer"},
+	},
+}
+
+var errorResponse = &Response{
+	Description: "error",
+	Content:     jsonContent(&Schema{Ref: "#/components/schemas/Error"}),
+}
+
+var unusedRespW *http.ResponseWriter
+var unusedReadCloser *io.ReadCloser
+var typeOfRespW = reflect.TypeOf(unusedRespW).Elem()
+var typeOfIoReadCloser = reflect.TypeOf(unusedReadCloser).Elem()
+var typeOfTime = reflect.TypeOf(time.Time{})
+var typeOfRawMessage = reflect.TypeOf(json.RawMessage{})
+
+var unusedReader *io.Reader
+var typeOfReader = reflect.TypeOf(unusedReader).Elem()
+var typeOfResponse = reflect.TypeOf(rpcutil.Response{})
+var typeOfBlob = reflect.TypeOf(rpcutil.Blob{})
+var typeOfStream = reflect.TypeOf(rpcutil.Stream{})
+var typeOfContent = reflect.TypeOf(httputil.Content{})
+var typeOfEventStream = reflect.TypeOf(rpcutil.EventStream{})
+var typeOfEvent = reflect.TypeOf(rpcutil.Event{})
+var unusedStatusCoder *httputil.StatusCoder
+var typeOfStatusCoder = reflect.TypeOf(unusedStatusCoder).Elem()
+var unusedListIterator *rpcutil.ListIterator
+var typeOfListIterator = reflect.TypeOf(unusedListIterator).Elem()
+
+func jsonContent(schema *Schema) map[string]*MediaType {
+
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+var binaryContent = map[string]*MediaType{
+	"application/octet-stream": {Schema: &Schema{Type: "string", Format: "binary"}},
+}
+
+// listContent returns the content of a list streamed as a JSON array or in
+// NDJSON, see rpcutil.ListIterator.
+func listContent(item *Schema) map[string]*MediaType {
+
+	return map[string]*MediaType{
+		"application/json":     {Schema: &Schema{Type: "array", Items: item}},
+		"application/x-ndjson": {Schema: item},
+	}
+}
+
+// isEventRet reports whether a return type is replied as Server-Sent Events,
+// see rpcutil.EventStream.
+func isEventRet(t reflect.Type) bool {
+
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return (t.Kind() == reflect.Chan && t.Elem() == typeOfEvent) || t == typeOfEventStream
+}
+
+// isBinaryRet reports whether a return type is replied as is, see rpcutil.Blob.
+func isBinaryRet(t reflect.Type) bool {
+
+	if t == nil {
+		return false
+	}
+	if t.Implements(typeOfReader) {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == typeOfBlob || t == typeOfStream || t == typeOfContent
+}
+
+// isCodedRet reports whether a return type may override the status code of
+// the method, see rpcutil.Response.
+func isCodedRet(t reflect.Type) bool {
+
+	if t.Implements(typeOfStatusCoder) {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == typeOfResponse || reflect.PtrTo(t).Implements(typeOfStatusCoder)
+}
+
+func (p *Generator) addRoute(route restrpc.Route) {
+
+	verb := strings.ToLower(route.Pattern[0])
+	op := &Operation{
+		OperationID: route.Method.Name,
+		Responses:   map[string]*Response{"default": errorResponse},
+	}
+
+	// path parameters
+	var segs []string
+	var nargs int
+	for _, seg := range route.Pattern[1:] {
+		if seg != "*" && seg != "**" && !(strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")) {
+			segs = append(segs, strings.ToLower(seg))
+			continue
+		}
+		nargs++
+		param := &Parameter{In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if seg == "*" || seg == "**" {
+			param.Name = "arg" + strconv.Itoa(nargs)
+		} else {
+			param.Name = seg[1 : len(seg)-1]
+			if strings.HasSuffix(param.Name, "...") {
+				param.Name = param.Name[:len(param.Name)-3]
+			} else if pos := strings.Index(param.Name, ":"); pos >= 0 {
+				param.Name, param.Schema = param.Name[:pos], constraintSchema(param.Name[pos+1:])
+			}
+		}
+		if seg == "**" || strings.HasSuffix(seg, "...}") {
+			param.Description = "the rest of the path, which may contain slashes"
+		}
+		segs = append(segs, "{"+param.Name+"}")
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	mtype := route.Method.Type
+	if strings.HasPrefix(route.Method.Name, "Ws") { // see hfac.NewWsHandler
+		op.Responses["101"] = &Response{Description: "Switching Protocols (WebSocket)"}
+	} else if mtype.NumIn() == 3 && mtype.In(1) == typeOfRespW { // DoYYYY(w, req)
+		op.Responses["200"] = &Response{Description: "OK"}
+	} else if spec, err := (rpcutil.HandlerCreator{ReqMayNotPtr: true}).Spec(route.Method); err == nil {
+		if spec.ReqType != nil && spec.ReqType.Kind() == reflect.Struct {
+			p.addArgs(op, verb, spec.ReqType)
+		}
+		var content map[string]*MediaType
+		switch {
+		case isEventRet(spec.RetType):
+			content = map[string]*MediaType{"text/event-stream": {Schema: &Schema{Type: "string"}}}
+		case spec.RetType == typeOfListIterator:
+			content = listContent(&Schema{})
+		case spec.RetType != nil && spec.RetType.Kind() == reflect.Chan:
+			content = listContent(p.schemaOf(spec.RetType.Elem()))
+		case isBinaryRet(spec.RetType):
+			content = binaryContent
+		case spec.RetType == typeOfResponse || spec.RetType == reflect.PtrTo(typeOfResponse):
+			// body is up to the method
+		case spec.RetType != nil:
+			content = jsonContent(p.schemaOf(spec.RetType))
+		case spec.HasErr:
+			content = jsonContent(&Schema{Type: "object"})
+		}
+		code := p.Creator.StatusCode(route.Method.Name)
+		key := strconv.Itoa(code)
+		if spec.RetType != nil && isCodedRet(spec.RetType) {
+			key = "2XX" // status code is up to the return value
+		} else if !httputil.BodyAllowed(code) {
+			content = nil
+		}
+		op.Responses[key] = &Response{Description: http.StatusText(code), Content: content}
+	}
+
+	urlPath := "/" + strings.Join(segs, "/")
+	item, ok := p.doc.Paths[urlPath]
+	if !ok {
+		item = &PathItem{}
+		p.doc.Paths[urlPath] = item
+	}
+	(*item)[verb] = op
+}
+
+func constraintSchema(constraint string) *Schema {
+
+	switch constraint {
+	case "int":
+		return &Schema{Type: "integer"}
+	case "uint":
+		return &Schema{Type: "integer", Format: "uint64"}
+	}
+	return &Schema{Type: "string", Pattern: "^(?:" + constraint + ")$"}
+}
+
+func (p *Generator) addArgs(op *Operation, verb string, reqType reflect.Type) {
+
+	// path parameters bound by `path` tags
+	for _, f := range fieldsOf(reqType, "path", false) {
+		for _, param := range op.Parameters {
+			if param.Name == f.name && param.Schema.Type == "string" && param.Schema.Pattern == "" {
+				param.Schema = p.schemaOf(f.typ)
+			}
+		}
+	}
+
+	if sf, ok := reqType.FieldByName("ReqBody"); ok {
+		body := &RequestBody{Required: true}
+		if (sf.Type.Kind() == reflect.Interface && typeOfIoReadCloser.Implements(sf.Type)) || sf.Type == reflect.TypeOf([]byte(nil)) {
+			body.Content = map[string]*MediaType{
+				"application/octet-stream": {Schema: &Schema{Type: "string", Format: "binary"}},
+			}
+		} else {
+			body.Content = jsonContent(p.schemaOf(sf.Type))
+		}
+		op.RequestBody = body
+		return
+	}
+
+	fields := fieldsOf(reqType, "json", true)
+	switch verb {
+	case "get", "delete", "head", "options":
+		for _, f := range fields {
+			op.Parameters = append(op.Parameters, &Parameter{
+				Name: f.name, In: "query", Required: f.required, Schema: p.schemaOf(f.typ),
+			})
+		}
+	default:
+		if len(fields) == 0 {
+			return
+		}
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for _, f := range fields {
+			schema.Properties[f.name] = p.schemaOf(f.typ)
+			if f.required {
+				schema.Required = append(schema.Required, f.name)
+			}
+		}
+		op.RequestBody = &RequestBody{
+			Content: map[string]*MediaType{
+				"application/json":                  {Schema: schema},
+				"application/x-www-form-urlencoded": {Schema: schema},
+			},
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type field struct {
+	name     string
+	typ      reflect.Type
+	required bool
+}
+
+// fieldsOf returns the fields of a struct named by their cate tags. If
+// skipPath is true, fields with `path` tags are skipped.
+func fieldsOf(t reflect.Type, cate string, skipPath bool) (fields []field) {
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get(cate)
+		if tag == "" {
+			if sf.Anonymous {
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					fields = append(fields, fieldsOf(ft, cate, skipPath)...)
+				}
+			}
+			if cate != "json" || sf.PkgPath != "" || sf.Anonymous || sf.Name == "ReqBody" {
+				continue
+			}
+			tag = sf.Name
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if skipPath && sf.Tag.Get("path") != "" {
+			continue
+		}
+		required := false
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			required = required || rule == "required"
+		}
+		fields = append(fields, field{name, sf.Type, required})
+	}
+	return
+}
+
+func (p *Generator) schemaOf(t reflect.Type) *Schema {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case typeOfTime:
+		return &Schema{Type: "string", Format: "date-time"}
+	case typeOfRawMessage:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: p.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: p.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return p.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + p.nameOf(t)}
+	}
+	return &Schema{}
+}
+
+func (p *Generator) nameOf(t reflect.Type) string {
+
+	if name, ok := p.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, ok := p.types[name]; ok {
+		name = path.Base(t.PkgPath()) + "." + name
+		for i := 2; ; i++ {
+			if _, ok := p.types[name]; !ok {
+				break
+			}
+			name = path.Base(t.PkgPath()) + "." + t.Name() + strconv.Itoa(i)
+		}
+	}
+	p.names[t], p.types[name] = name, t
+	p.doc.Components.Schemas[name] = p.structSchema(t)
+	return name
+}
+
+func (p *Generator) structSchema(t reflect.Type) *Schema {
+
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, f := range fieldsOf(t, "json", false) {
+		schema.Properties[f.name] = p.schemaOf(f.typ)
+		if f.required {
+			schema.Required = append(schema.Required, f.name)
+		}
+	}
+	return schema
+}
+
+// ---------------------------------------------------------------------------
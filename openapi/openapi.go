@@ -0,0 +1,139 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restrpc"
+)
+
+// ---------------------------------------------------------------------------
+
+// Document represents an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []*Server            `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+// Info represents the metadata of an API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server represents a server of an API.
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem represents the operations of a path, keyed by lower-cased method.
+type PathItem map[string]*Operation
+
+// Operation represents an API operation.
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter represents a path, query or header parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody represents a request body.
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response represents a response.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType represents the content of a body in a media type.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Components holds reusable objects of a document.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// Schema represents a JSON schema.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// JSON encodes the document in JSON.
+func (p *Document) JSON() ([]byte, error) {
+
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// YAML encodes the document in YAML.
+func (p *Document) YAML() ([]byte, error) {
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return encodeYAML(v), nil
+}
+
+// ---------------------------------------------------------------------------
+
+// Handler returns a http handler serving the document. It replies YAML if the
+// request path ends with ".yaml" or ".yml", and JSON otherwise.
+func Handler(doc *Document) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, ".yaml") || strings.HasSuffix(req.URL.Path, ".yml") {
+			b, err := doc.YAML()
+			if err != nil {
+				httputil.Error(w, err)
+				return
+			}
+			httputil.ReplyWith(w, 200, "application/yaml", b)
+			return
+		}
+		httputil.Reply(w, 200, doc)
+	})
+}
+
+// Serve installs Handler(doc) at path of mux, eg. Serve(mux, "/openapi.json", doc).
+func Serve(mux restrpc.Mux, path string, doc *Document) {
+
+	mux.Handle("GET "+path, Handler(doc))
+}
+
+// ---------------------------------------------------------------------------
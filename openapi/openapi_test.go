@@ -0,0 +1,197 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+	"github.com/qiniu/http/websocket"
+)
+
+// ---------------------------------------------------------------------------
+
+type fooArgs struct {
+	A string `json:"a" validate:"required"`
+	B int    `json:"b"`
+}
+
+type fooRet struct {
+	ID   string  `json:"id"`
+	Next *fooRet `json:"next,omitempty"`
+}
+
+type hostsArgs struct {
+	ReqBody map[string]string
+}
+
+type uploadArgs struct {
+	Bucket  string `path:"bucket"`
+	ReqBody io.ReadCloser
+}
+
+type Service struct{}
+
+func (p *Service) PostFoo_Bar(args *fooArgs, env *restrpc.Env) (ret fooRet, err error) { return }
+func (p *Service) GetFoo_(args *fooArgs, env *restrpc.Env) (ret []fooRet, err error)   { return }
+func (p *Service) PostHosts_(args *hostsArgs, env *restrpc.Env) (err error)            { return }
+func (p *Service) PutUpload(args *uploadArgs) (err error)                              { return }
+func (p *Service) GetDownload_(env *restrpc.Env) (ret io.ReadCloser, err error)        { return }
+func (p *Service) GetEvents(env *restrpc.Env) (ret <-chan rpcutil.Event, err error)    { return }
+func (p *Service) GetNumbers(env *restrpc.Env) (ret <-chan int, err error)             { return }
+func (p *Service) WsFeed(conn *websocket.Conn, env *restrpc.Env) (err error)           { return }
+
+func TestGenerator(t *testing.T) {
+
+	svc := new(Service)
+	router := &restrpc.Router{PatternPrefix: "/v1", Mux: restrpc.NewServeMux()}
+	mux := router.Register(svc)
+
+	g := NewGenerator(Info{Title: "foo", Version: "1.0"})
+	g.Register(router, svc)
+	g.Register(router, svc, [][2]string{{"PUT /buckets/{bucket}/objects/{key...}", "PutUpload"}})
+	doc := g.Document()
+	Serve(mux, "/openapi.json", doc)
+	Serve(mux, "/openapi.yaml", doc)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != 200 {
+		t.Fatal("GET /openapi.json failed:", w.Code)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatal("json.Unmarshal failed:", err)
+	}
+
+	cases := [][2]string{
+		{"openapi", `"3.0.3"`},
+		{"paths./v1/foo/{arg1}/bar.post.operationId", `"PostFoo_Bar"`},
+		{"paths./v1/foo/{arg1}/bar.post.parameters", `[{"in":"path","name":"arg1","required":true,"schema":{"type":"string"}}]`},
+		{"paths./v1/foo/{arg1}/bar.post.requestBody.content.application/json.schema.required", `["a"]`},
+		{"paths./v1/foo/{arg1}/bar.post.responses.200.content.application/json.schema", `{"$ref":"#/components/schemas/fooRet"}`},
+		{"paths./v1/foo/{arg1}/bar.post.responses.default.content.application/json.schema", `{"$ref":"#/components/schemas/Error"}`},
+		{"paths./v1/foo/{arg1}.get.parameters.1", `{"in":"query","name":"a","required":true,"schema":{"type":"string"}}`},
+		{"paths./v1/foo/{arg1}.get.responses.200.content.application/json.schema.items", `{"$ref":"#/components/schemas/fooRet"}`},
+		{"paths./v1/hosts/{arg1}.post.requestBody.content.application/json.schema", `{"additionalProperties":{"type":"string"},"type":"object"}`},
+		{"paths./v1/upload.put.requestBody.content.application/octet-stream.schema.format", `"binary"`},
+		{"paths./v1/download/{arg1}.get.responses.200.content.application/octet-stream.schema.format", `"binary"`},
+		{"paths./v1/events.get.responses.200.content.text/event-stream.schema.type", `"string"`},
+		{"paths./v1/numbers.get.responses.200.content.application/x-ndjson.schema", `{"format":"int32","type":"integer"}`},
+		{"paths./v1/feed.get.responses.101.description", `"Switching Protocols (WebSocket)"`},
+		{"paths./v1/buckets/{bucket}/objects/{key}.put.parameters.1.name", `"key"`},
+		{"components.schemas.fooRet.properties.next", `{"$ref":"#/components/schemas/fooRet"}`},
+	}
+	for _, c := range cases {
+		if got := lookup(v, c[0]); got != c[1] {
+			t.Fatal("unexpected", c[0], "=>", got, "expected:", c[1])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.yaml", nil))
+	yaml := w.Body.String()
+	if w.Header().Get("Content-Type") != "application/yaml" ||
+		!strings.HasPrefix(yaml, "components:\n  schemas:\n    Error:\n") ||
+		!strings.Contains(yaml, "\n  \"/v1/foo/{arg1}/bar\":\n    post:\n") ||
+		!strings.Contains(yaml, "\n      parameters:\n        -\n          in: \"path\"\n") {
+		t.Fatal("unexpected yaml:", yaml)
+	}
+}
+
+type jobRet struct {
+	ID string `json:"id"`
+}
+
+type acceptedRet struct {
+	ID string `json:"id"`
+}
+
+func (p acceptedRet) StatusCode() int { return 202 }
+
+type jobService struct{}
+
+func (p *jobService) PostJobs(env *restrpc.Env) (ret jobRet, err error)             { return }
+func (p *jobService) DeleteJobs_(env *restrpc.Env) (err error)                      { return }
+func (p *jobService) PostJobs_Cancel(env *restrpc.Env) (err error)                  { return }
+func (p *jobService) PostJobs_Retry(env *restrpc.Env) (ret *acceptedRet, err error) { return }
+func (p *jobService) PutJobs_(env *restrpc.Env) (ret *rpcutil.Response, err error)  { return }
+func (p *jobService) GetJobs_(env *restrpc.Env) (ret jobRet, err error)             { return }
+
+func TestStatusCodes(t *testing.T) {
+
+	creator := rpcutil.HandlerCreator{
+		StatusCodes: map[string]int{"Post": 201, "Delete": 204, "PostJobs_Cancel": 200},
+	}
+	svc := new(jobService)
+	router := &restrpc.Router{Factory: restrpc.NewFactory(creator), Mux: restrpc.NewServeMux()}
+	router.Register(svc)
+
+	g := NewGenerator(Info{Title: "jobs", Version: "1.0"})
+	g.Creator = creator
+	g.Register(router, svc)
+	b, err := json.Marshal(g.Document())
+	if err != nil {
+		t.Fatal("json.Marshal failed:", err)
+	}
+	var v map[string]interface{}
+	json.Unmarshal(b, &v)
+
+	cases := [][2]string{
+		{"paths./jobs.post.responses.201.content.application/json.schema", `{"$ref":"#/components/schemas/jobRet"}`},
+		{"paths./jobs.post.responses.200", `<not found>`},
+		{"paths./jobs/{arg1}.delete.responses.204", `{"description":"No Content"}`},
+		{"paths./jobs/{arg1}/cancel.post.responses.200.description", `"OK"`},
+		{"paths./jobs/{arg1}/retry.post.responses.2XX.content.application/json.schema", `{"$ref":"#/components/schemas/acceptedRet"}`},
+		{"paths./jobs/{arg1}.put.responses.2XX.description", `"OK"`},
+		{"paths./jobs/{arg1}.get.responses.200.content.application/json.schema", `{"$ref":"#/components/schemas/jobRet"}`},
+	}
+	for _, c := range cases {
+		if got := lookup(v, c[0]); got != c[1] {
+			t.Fatal("unexpected", c[0], "=>", got, "expected:", c[1])
+		}
+	}
+}
+
+// lookup finds a value by a dotted path; path items may contain dots, so the
+// longest matching key is taken.
+func lookup(v interface{}, path string) string {
+
+	for path != "" {
+		switch v1 := v.(type) {
+		case map[string]interface{}:
+			found := false
+			for i := len(path); i > 0; i-- {
+				if i < len(path) && path[i] != '.' {
+					continue
+				}
+				if item, ok := v1[path[:i]]; ok {
+					v, path, found = item, strings.TrimPrefix(path[i:], "."), true
+					break
+				}
+			}
+			if !found {
+				return "<not found>"
+			}
+		case []interface{}:
+			pos := strings.Index(path+".", ".")
+			idx := 0
+			for _, c := range path[:pos] {
+				idx = idx*10 + int(c-'0')
+			}
+			if idx >= len(v1) {
+				return "<not found>"
+			}
+			v, path = v1[idx], strings.TrimPrefix(path[pos:], ".")
+		default:
+			return "<not found>"
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,99 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+
+// encodeYAML encodes a value decoded by encoding/json (maps, slices and
+// scalars) in YAML. Strings are always double-quoted, which is valid YAML
+// with the same escapes as JSON.
+func encodeYAML(v interface{}) []byte {
+
+	var b bytes.Buffer
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		if !isEmpty(v) {
+			writeYAML(&b, v, 0)
+			return b.Bytes()
+		}
+	}
+	writeScalar(&b, v)
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func writeYAML(b *bytes.Buffer, v interface{}, indent int) {
+
+	prefix := strings.Repeat("  ", indent)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(prefix)
+			writeKey(b, k)
+			b.WriteByte(':')
+			writeValue(b, v[k], indent)
+		}
+	case []interface{}:
+		for _, item := range v {
+			b.WriteString(prefix)
+			b.WriteByte('-')
+			writeValue(b, item, indent)
+		}
+	}
+}
+
+func writeValue(b *bytes.Buffer, v interface{}, indent int) {
+
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		if !isEmpty(v) {
+			b.WriteByte('\n')
+			writeYAML(b, v, indent+1)
+			return
+		}
+	}
+	b.WriteByte(' ')
+	writeScalar(b, v)
+	b.WriteByte('\n')
+}
+
+func isEmpty(v interface{}) bool {
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func writeScalar(b *bytes.Buffer, v interface{}) {
+
+	s, _ := json.Marshal(v) // {} and [] for empty maps and slices
+	b.Write(s)
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func writeKey(b *bytes.Buffer, k string) {
+
+	if plainKey.MatchString(k) {
+		b.WriteString(k)
+		return
+	}
+	writeScalar(b, k)
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,384 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/qiniu/http/formutil"
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restrpc"
+)
+
+/* ---------------------------------------------------------------------------
+
+Client 按照与 restrpc.Router.Register 相同的约定调用 restrpc 服务：由方法名得到 HTTP 方法和路径，
+由 args 得到请求参数，并把 {"error": ..., "errno": ...} 形式的错误解析为 *httputil.ErrorInfo。
+
+服务端：
+
+	func (p *Service) PostFoo_Bar(args *fooBarArgs, env *restrpc.Env) (ret fooBarRet, err error)
+
+客户端可以直接调用：
+
+	c := &restclient.Client{BaseURL: "http://foo.com", PatternPrefix: "/v1"}
+	var ret fooBarRet
+	err := c.Call(ctx, "PostFoo_Bar", &ret, &fooBarArgs{A: "1"}, "foo123") // POST /v1/foo/foo123/bar
+
+或者声明一个由函数字段组成的 stub，用 Bind 生成各个函数：
+
+	type FooClient struct {
+		PostFoo_Bar func(ctx context.Context, args *fooBarArgs, cmdArgs ...string) (fooBarRet, error)
+		GetFoo_     func(ctx context.Context, cmdArgs ...string) (fooInfo, error)
+	}
+
+	var foo FooClient
+	err := c.Bind(&foo)
+	ret, err := foo.PostFoo_Bar(ctx, &fooBarArgs{A: "1"}, "foo123")
+
+(Go 不能在运行时实现一个 interface，所以 stub 用函数字段代替接口方法。)
+
+stub 函数的规格为：
+
+	func([ctx context.Context, ][args ZZZZ, ][cmdArgs ...string]) ([ret RRRR, ]err error)
+
+参数规则：
+
+- 路径由方法名推导；手工指定路由的方法需要把同样的路由表设置到 Client.Routes。
+- 路径中的 * 和 ** 按顺序取 cmdArgs；命名参数 {name} 优先取 args 中 `path:"name"` 的字段。
+- GET/DELETE/HEAD/OPTIONS 请求的 args 编码为 query；其余请求的 args 编码为 JSON body
+  (Form 为 true 时编码为 form)。
+- args 有 ReqBody 字段时，以 ReqBody 作为 body：io.Reader 与 []byte 原样发送，其余编码为 JSON。
+
+流式回复列表的方法(见 rpcutil.ListIterator)用 CallList 调用，逐个读取元素，见 ListReader。
+
+// -------------------------------------------------------------------------*/
+
+// Client is a client of restrpc services.
+type Client struct {
+	BaseURL       string       // eg. "http://foo.com"
+	PatternPrefix string       // the same as restrpc.Router.PatternPrefix
+	Separator     string       // the same as restrpc.Router.Separator
+	CatchAll      bool         // the same as restrpc.Router.CatchAll
+	Routes        [][2]string  // routes installed manually, the same as passed to restrpc.Router.Register
+	Header        http.Header  // headers added to every request, eg. Authorization
+	Form          bool         // encode args of POST/PUT/PATCH requests as forms rather than JSON
+	Client        *http.Client // http.DefaultClient if nil
+}
+
+// ErrTooFewCmdArgs is returned if cmdArgs is not enough for the path of a method.
+var ErrTooFewCmdArgs = errors.New("too few cmdArgs")
+
+// Call calls the route of a service method by name, and decodes the JSON
+// reply into ret (if not nil).
+func (p *Client) Call(ctx context.Context, method string, ret interface{}, args interface{}, cmdArgs ...string) error {
+
+	req, err := p.NewRequest(ctx, method, args, cmdArgs...)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return DecodeResponse(resp, ret)
+}
+
+// NewRequest creates the http request calling a service method.
+func (p *Client) NewRequest(ctx context.Context, method string, args interface{}, cmdArgs ...string) (*http.Request, error) {
+
+	pattern, ok := p.patternOf(method)
+	if !ok {
+		return nil, errors.New("restclient: invalid method name: " + method)
+	}
+
+	var argv reflect.Value
+	if args != nil {
+		argv = reflect.Indirect(reflect.ValueOf(args))
+		if argv.Kind() != reflect.Struct {
+			return nil, errors.New("restclient: args is not a struct: " + method)
+		}
+	}
+
+	path, err := buildPath(pattern, argv, cmdArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := strings.ToUpper(pattern[0])
+	var body io.Reader
+	var ctype, query string
+	if argv.IsValid() {
+		switch verb {
+		case "GET", "DELETE", "HEAD", "OPTIONS":
+			b, err := formutil.EncodeValue(argv, "json")
+			if err != nil {
+				return nil, err
+			}
+			query = string(b)
+		default:
+			if body, ctype, err = p.encodeBody(argv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	u := strings.TrimSuffix(p.BaseURL, "/") + path
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequest(verb, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	return req, nil
+}
+
+// patternOf returns the pattern of a method, as restrpc.Router.Register does.
+func (p *Client) patternOf(method string) (restrpc.Pattern, bool) {
+
+	for _, item := range p.Routes {
+		if item[1] != method {
+			continue
+		}
+		pattern := item[0]
+		if p.PatternPrefix != "" {
+			if pos := strings.Index(pattern, "/"); pos > 0 {
+				pattern = pattern[:pos] + p.PatternPrefix + pattern[pos:]
+			}
+		}
+		return restrpc.NewPattern(pattern), true
+	}
+	return restrpc.MethodPatternEx(method, p.PatternPrefix, p.Separator, p.CatchAll)
+}
+
+func buildPath(pattern restrpc.Pattern, argv reflect.Value, cmdArgs []string) (string, error) {
+
+	var named url.Values
+	var b strings.Builder
+	for _, seg := range pattern[1:] {
+		b.WriteByte('/')
+		if seg != "*" && seg != "**" && !(strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")) {
+			b.WriteString(strings.ToLower(seg))
+			continue
+		}
+		catchAll := seg == "**" || strings.HasSuffix(seg, "...}")
+		var arg string
+		var found bool
+		if strings.HasPrefix(seg, "{") && argv.IsValid() {
+			name := strings.TrimSuffix(seg[1:len(seg)-1], "...")
+			if pos := strings.Index(name, ":"); pos >= 0 {
+				name = name[:pos]
+			}
+			if named == nil {
+				named = pathValues(argv)
+			}
+			arg, found = named.Get(name), named[name] != nil
+		}
+		if !found {
+			if len(cmdArgs) == 0 {
+				return "", ErrTooFewCmdArgs
+			}
+			arg, cmdArgs = cmdArgs[0], cmdArgs[1:]
+		}
+		if catchAll {
+			parts := strings.Split(arg, "/")
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			b.WriteString(strings.Join(parts, "/"))
+		} else {
+			b.WriteString(url.PathEscape(arg))
+		}
+	}
+	return b.String(), nil
+}
+
+// pathValues returns the values of the fields with `path` tags.
+func pathValues(argv reflect.Value) url.Values {
+
+	values := make(url.Values)
+	b, err := formutil.EncodeValue(argv, "path")
+	if err == nil {
+		values, _ = url.ParseQuery(string(b))
+	}
+	return values
+}
+
+func (p *Client) encodeBody(argv reflect.Value) (body io.Reader, ctype string, err error) {
+
+	if sf := argv.FieldByName("ReqBody"); sf.IsValid() {
+		switch v := sf.Interface().(type) {
+		case io.Reader:
+			return v, "application/octet-stream", nil
+		case []byte:
+			return bytes.NewReader(v), "application/octet-stream", nil
+		}
+		b, err := json.Marshal(sf.Interface())
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(b), "application/json", nil
+	}
+
+	if p.Form {
+		b, err := formutil.EncodeValue(argv, "json")
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(b), "application/x-www-form-urlencoded", nil
+	}
+	b, err := json.Marshal(argv.Interface())
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(b), "application/json", nil
+}
+
+// ---------------------------------------------------------------------------
+
+var unusedError *error
+var unusedContext *context.Context
+var typeOfError = reflect.TypeOf(unusedError).Elem()
+var typeOfContext = reflect.TypeOf(unusedContext).Elem()
+var typeOfStrings = reflect.TypeOf([]string(nil))
+
+// Bind fills the func fields of the struct stub points to, so that each of
+// them calls the service method of the same name (or the name in its
+// `method` tag). Fields of other types are left unchanged.
+func (p *Client) Bind(stub interface{}) error {
+
+	v := reflect.ValueOf(stub)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("restclient: stub is not a pointer to struct")
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type.Kind() != reflect.Func || sf.PkgPath != "" {
+			continue
+		}
+		method := sf.Name
+		if tag := sf.Tag.Get("method"); tag != "" {
+			method = tag
+		}
+		fn, err := p.makeFunc(method, sf.Type)
+		if err != nil {
+			return err
+		}
+		v.Field(i).Set(fn)
+	}
+	return nil
+}
+
+// makeFunc makes a func of type
+// func([ctx context.Context, ][args ZZZZ, ][cmdArgs ...string]) ([ret RRRR, ]err error).
+func (p *Client) makeFunc(method string, ft reflect.Type) (reflect.Value, error) {
+
+	if _, ok := p.patternOf(method); !ok {
+		return reflect.Value{}, errors.New("restclient: invalid method name: " + method)
+	}
+
+	nin, nout := ft.NumIn(), ft.NumOut()
+	if nout == 0 || nout > 2 || ft.Out(nout-1) != typeOfError {
+		return reflect.Value{}, errors.New("restclient: func must return error: " + method)
+	}
+	hasCtx := nin > 0 && ft.In(0) == typeOfContext
+	hasCmdArgs := ft.IsVariadic() && ft.In(nin-1) == typeOfStrings
+	argsIdx := -1
+	if n := nin - boolToInt(hasCtx) - boolToInt(hasCmdArgs); n == 1 {
+		argsIdx = boolToInt(hasCtx)
+	} else if n != 0 {
+		return reflect.Value{}, errors.New("restclient: too many arguments: " + method)
+	}
+
+	var retType reflect.Type
+	if nout == 2 {
+		retType = ft.Out(0)
+	}
+
+	return reflect.MakeFunc(ft, func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if hasCtx && !in[0].IsNil() {
+			ctx = in[0].Interface().(context.Context)
+		}
+		var args interface{}
+		if argsIdx >= 0 && !(in[argsIdx].Kind() == reflect.Ptr && in[argsIdx].IsNil()) {
+			args = in[argsIdx].Interface()
+		}
+		var cmdArgs []string
+		if hasCmdArgs {
+			cmdArgs = in[nin-1].Interface().([]string)
+		}
+
+		var retv reflect.Value
+		var ret interface{}
+		if retType != nil {
+			retv = reflect.New(retType)
+			ret = retv.Interface()
+		}
+		err := p.Call(ctx, method, ret, args, cmdArgs...)
+
+		errv := reflect.Zero(typeOfError)
+		if err != nil {
+			errv = reflect.ValueOf(&err).Elem()
+		}
+		if retType == nil {
+			return []reflect.Value{errv}
+		}
+		return []reflect.Value{retv.Elem(), errv}
+	}), nil
+}
+
+func boolToInt(b bool) int {
+
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ---------------------------------------------------------------------------
+
+// DecodeResponse decodes the JSON reply of a successful response into ret (if
+// not nil), or the error reply of a failed response into an *httputil.ErrorInfo.
+// It closes resp.Body.
+func DecodeResponse(resp *http.Response, ret interface{}) error {
+
+	if resp.StatusCode/100 != 2 {
+		return httputil.ResponseError(resp)
+	}
+	defer resp.Body.Close()
+
+	if ret == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err := json.NewDecoder(resp.Body).Decode(ret)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,195 @@
+package restclient_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restclient"
+	"github.com/qiniu/http/restrpc"
+)
+
+// ---------------------------------------------------------------------------
+
+type fooArgs struct {
+	A string `json:"a"`
+	B int    `json:"b"`
+}
+
+type fooRet struct {
+	Id string `json:"id"`
+	A  string `json:"a"`
+	B  int    `json:"b"`
+}
+
+type objectArgs struct {
+	Bucket string `path:"bucket"`
+	V      string `json:"v"`
+}
+
+type uploadArgs struct {
+	ReqBody []byte
+}
+
+type service struct{}
+
+func (p *service) PostFoo_Bar(args *fooArgs, env *restrpc.Env) (ret fooRet, err error) {
+	return fooRet{Id: env.Args[0], A: args.A, B: args.B}, nil
+}
+
+func (p *service) GetFoo_(args *fooArgs, env *restrpc.Env) (ret fooRet, err error) {
+	return fooRet{Id: env.Args[0], A: args.A}, nil
+}
+
+func (p *service) DeleteFoo_(env *restrpc.Env) error {
+	return httputil.NewError(404, "no such foo: "+env.Args[0])
+}
+
+func (p *service) GetObject(args *objectArgs, env *restrpc.Env) (ret fooRet, err error) {
+	return fooRet{Id: args.Bucket + "/" + env.Args[1], A: args.V}, nil
+}
+
+func (p *service) PutUpload(args *uploadArgs) (ret fooRet, err error) {
+	return fooRet{A: string(args.ReqBody)}, nil
+}
+
+var routes = [][2]string{
+	{"GET /buckets/{bucket}/objects/*", "GetObject"},
+}
+
+func newServer() *httptest.Server {
+
+	router := &restrpc.Router{PatternPrefix: "/v1", Mux: restrpc.NewServeMux()}
+	router.Register(new(service), routes)
+	router.Register(new(service))
+	return httptest.NewServer(router.Mux)
+}
+
+func TestCall(t *testing.T) {
+
+	svr := newServer()
+	defer svr.Close()
+
+	c := &restclient.Client{BaseURL: svr.URL, PatternPrefix: "/v1", Routes: routes}
+	ctx := context.Background()
+
+	var ret fooRet
+	err := c.Call(ctx, "PostFoo_Bar", &ret, &fooArgs{A: "x", B: 2}, "a b")
+	if err != nil || ret != (fooRet{Id: "a b", A: "x", B: 2}) {
+		t.Fatal("PostFoo_Bar:", ret, err)
+	}
+
+	ret = fooRet{}
+	err = c.Call(ctx, "GetFoo_", &ret, &fooArgs{A: "y"}, "a/b")
+	if err != nil || ret != (fooRet{Id: "a/b", A: "y"}) {
+		t.Fatal("GetFoo_:", ret, err)
+	}
+
+	err = c.Call(ctx, "DeleteFoo_", nil, nil, "abc")
+	if e, ok := err.(*httputil.ErrorInfo); !ok || e.Code != 404 || e.Err != "no such foo: abc" {
+		t.Fatal("DeleteFoo_:", err)
+	}
+
+	err = c.Call(ctx, "GetFoo_", nil, nil)
+	if err != restclient.ErrTooFewCmdArgs {
+		t.Fatal("GetFoo_ without cmdArgs:", err)
+	}
+
+	ret = fooRet{}
+	err = c.Call(ctx, "PutUpload", &ret, &uploadArgs{ReqBody: []byte("hello")})
+	if err != nil || ret.A != "hello" {
+		t.Fatal("PutUpload:", ret, err)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+
+	c := &restclient.Client{BaseURL: "http://foo.com/", PatternPrefix: "/v1", Routes: routes}
+	req, err := c.NewRequest(nil, "GetObject", &objectArgs{Bucket: "b1", V: "1"}, "k/1")
+	if err != nil {
+		t.Fatal("NewRequest failed:", err)
+	}
+	if req.Method != "GET" || req.URL.String() != "http://foo.com/v1/buckets/b1/objects/k%2F1?v=1" {
+		t.Fatal("NewRequest:", req.Method, req.URL)
+	}
+
+	req, err = c.NewRequest(nil, "PostFoo_Bar", &fooArgs{A: "x"}, "1")
+	if err != nil {
+		t.Fatal("NewRequest failed:", err)
+	}
+	b, _ := ioutil.ReadAll(req.Body)
+	if req.URL.Path != "/v1/foo/1/bar" || req.Header.Get("Content-Type") != "application/json" || string(b) != `{"a":"x","b":0}` {
+		t.Fatal("NewRequest:", req.URL, req.Header, string(b))
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type fooClient struct {
+	PostFoo_Bar func(ctx context.Context, args *fooArgs, cmdArgs ...string) (fooRet, error)
+	GetObject   func(ctx context.Context, args *objectArgs, cmdArgs ...string) (*fooRet, error) `method:"GetObject"`
+	DeleteFoo_  func(cmdArgs ...string) error
+}
+
+func TestBind(t *testing.T) {
+
+	svr := newServer()
+	defer svr.Close()
+
+	c := &restclient.Client{BaseURL: svr.URL, PatternPrefix: "/v1", Routes: routes}
+	var foo fooClient
+	if err := c.Bind(&foo); err != nil {
+		t.Fatal("Bind failed:", err)
+	}
+
+	ctx := context.Background()
+	ret, err := foo.PostFoo_Bar(ctx, &fooArgs{A: "x"}, "1")
+	if err != nil || ret != (fooRet{Id: "1", A: "x"}) {
+		t.Fatal("PostFoo_Bar:", ret, err)
+	}
+
+	ret1, err := foo.GetObject(ctx, &objectArgs{Bucket: "b1", V: "v"}, "k1")
+	if err != nil || *ret1 != (fooRet{Id: "b1/k1", A: "v"}) {
+		t.Fatal("GetObject:", ret1, err)
+	}
+
+	err = foo.DeleteFoo_("abc")
+	if e, ok := err.(*httputil.ErrorInfo); !ok || e.Code != 404 {
+		t.Fatal("DeleteFoo_:", err)
+	}
+
+	var bad struct {
+		Foo func() string
+	}
+	if err = c.Bind(&bad); err == nil {
+		t.Fatal("Bind bad stub: no error")
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+func TestDecodeProblem(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	httputil.Problem(w, httputil.NewErrorEx(403, 612, "balance is 30").
+		WithType("https://example.com/probs/out-of-credit", "no credit").
+		WithExtension("balance", 30))
+
+	err := restclient.DecodeResponse(w.Result(), nil)
+	e, ok := err.(*httputil.ErrorInfo)
+	if !ok || e.Code != 403 || e.Errno != 612 || e.Err != "balance is 30" || e.Type != "https://example.com/probs/out-of-credit" ||
+		e.Title != "no credit" || e.Extensions()["balance"] != 30.0 {
+		t.Fatal("DecodeResponse:", err)
+	}
+
+	w = httptest.NewRecorder()
+	httputil.Problem(w, httputil.NewError(404, ""))
+	err = restclient.DecodeResponse(w.Result(), nil)
+	if e, ok := err.(*httputil.ErrorInfo); !ok || e.Code != 404 || e.Err != "Not Found" || e.Type != "" || e.Title != "" {
+		t.Fatal("DecodeResponse:", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,130 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/qiniu/http/httputil"
+)
+
+// ---------------------------------------------------------------------------
+
+// ListReader decodes a list streamed by a service method (see
+// rpcutil.ListIterator), either in NDJSON or as a JSON array:
+//
+//	r, err := c.CallList(ctx, "GetBuckets_Objects", nil, "bucket1")
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//	for {
+//		var obj objectInfo
+//		if err = r.Next(&obj); err != nil {
+//			break // io.EOF after the last item
+//		}
+//		...
+//	}
+type ListReader struct {
+	resp    *http.Response
+	dec     *json.Decoder
+	array   bool
+	started bool
+	err     error // io.EOF or the error which ended the list
+}
+
+var errInvalidList = errors.New("restclient: invalid list")
+
+// NewListReader creates a ListReader of resp. It returns the error of resp
+// (see httputil.ResponseError) if resp is not successful.
+func NewListReader(resp *http.Response) (*ListReader, error) {
+
+	if resp.StatusCode/100 != 2 {
+		return nil, httputil.ResponseError(resp)
+	}
+	r := &ListReader{resp: resp, dec: json.NewDecoder(resp.Body)}
+	r.array = httputil.MediaType(resp.Header.Get("Content-Type")) != httputil.NDJSONMediaType
+	return r, nil
+}
+
+// Next decodes the next item into item. It returns io.EOF after the last
+// item, or the error which ended the list on the server (an
+// *httputil.ErrorInfo, see httputil.StreamError).
+func (r *ListReader) Next(item interface{}) error {
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.array && !r.started {
+		r.started = true
+		tok, err := r.dec.Token()
+		if err != nil {
+			return r.fail(err)
+		}
+		if d, ok := tok.(json.Delim); !ok || d != '[' {
+			r.err = errInvalidList
+			return r.err
+		}
+	}
+	if r.array && !r.dec.More() {
+		if _, err := r.dec.Token(); err != nil { // ']'
+			return r.fail(err)
+		}
+		r.err = io.EOF
+		return io.EOF
+	}
+	if err := r.dec.Decode(item); err != nil {
+		if err == io.EOF && !r.array {
+			if r.err = httputil.StreamError(r.resp); r.err == nil {
+				r.err = io.EOF
+			}
+			return r.err
+		}
+		return r.fail(err)
+	}
+	return nil
+}
+
+// fail returns the error in the trailer (available after the body is read to
+// EOF), or err if none.
+func (r *ListReader) fail(err error) error {
+
+	if r.err = httputil.StreamError(r.resp); r.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // an array not ended by ]
+		}
+		r.err = err
+	}
+	return r.err
+}
+
+// Close closes the body of the response.
+func (r *ListReader) Close() error {
+
+	return r.resp.Body.Close()
+}
+
+// CallList calls the route of a service method returning a streamed list, in
+// NDJSON. The returned ListReader should be closed.
+func (p *Client) CallList(ctx context.Context, method string, args interface{}, cmdArgs ...string) (*ListReader, error) {
+
+	req, err := p.NewRequest(ctx, method, args, cmdArgs...)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", httputil.NDJSONMediaType)
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return NewListReader(resp)
+}
+
+// ---------------------------------------------------------------------------
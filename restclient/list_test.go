@@ -0,0 +1,201 @@
+package restclient_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restclient"
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+)
+
+// ---------------------------------------------------------------------------
+
+type item struct {
+	N int `json:"n"`
+}
+
+type listService struct{}
+
+// GetItems_ lists items 1..n, or fails after 2 items if n is "fail".
+func (p *listService) GetItems_(env *restrpc.Env) (ret rpcutil.ListIterator, err error) {
+
+	n, fail := 3, env.Args[0] == "fail"
+	if !fail {
+		if n, err = strconv.Atoi(env.Args[0]); err != nil {
+			return
+		}
+	}
+	i := 0
+	return rpcutil.ListFunc(func() (interface{}, error) {
+		if i++; fail && i > 2 {
+			return nil, httputil.NewErrorEx(503, 1234, "backend unavailable")
+		}
+		if i > n {
+			return nil, io.EOF
+		}
+		return &item{N: i}, nil
+	}), nil
+}
+
+func (p *listService) GetNumbers(env *restrpc.Env) (ret <-chan int, err error) {
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= 3; i++ {
+			ch <- i
+		}
+	}()
+	return ch, nil
+}
+
+func readList(r *restclient.ListReader) (items []int, err error) {
+
+	defer r.Close()
+	for {
+		var v item
+		if err = r.Next(&v); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		items = append(items, v.N)
+	}
+}
+
+func TestListReader(t *testing.T) {
+
+	router := &restrpc.Router{Mux: restrpc.NewServeMux()}
+	svr := httptest.NewServer(router.Register(new(listService)))
+	defer svr.Close()
+
+	c := &restclient.Client{BaseURL: svr.URL}
+	ctx := context.Background()
+
+	cases := []struct {
+		arg   string
+		items string
+		err   string
+	}{
+		{"3", "[1 2 3]", ""},
+		{"0", "[]", ""},
+		{"fail", "[1 2]", "backend unavailable"},
+	}
+	for _, accept := range []string{httputil.NDJSONMediaType, ""} {
+		for _, c1 := range cases {
+			var r *restclient.ListReader
+			var err error
+			if accept != "" {
+				r, err = c.CallList(ctx, "GetItems_", nil, c1.arg)
+			} else { // a JSON array
+				var resp *http.Response
+				if resp, err = http.Get(svr.URL + "/items/" + c1.arg); err == nil {
+					r, err = restclient.NewListReader(resp)
+				}
+			}
+			if err != nil {
+				t.Fatal("CallList failed:", accept, c1.arg, err)
+			}
+			items, err := readList(r)
+			if fmtInts(items) != c1.items {
+				t.Fatal("unexpected items:", accept, c1.arg, items)
+			}
+			if c1.err == "" {
+				if err != nil {
+					t.Fatal("unexpected error:", accept, c1.arg, err)
+				}
+				continue
+			}
+			if e, ok := err.(*httputil.ErrorInfo); !ok || e.Code != 503 || e.Errno != 1234 || e.Err != c1.err {
+				t.Fatal("unexpected error:", accept, c1.arg, err)
+			}
+		}
+	}
+
+	resp, err := http.Get(svr.URL + "/items/2")
+	if err != nil {
+		t.Fatal("GET /items/2 failed:", err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "[{\"n\":1},\n{\"n\":2}]\n" || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal("unexpected resp:", resp.Header, string(b))
+	}
+
+	if _, err = c.CallList(ctx, "GetItems_", nil, "x"); err == nil {
+		t.Fatal("CallList of an invalid list should fail")
+	}
+
+	r, err := c.CallList(ctx, "GetNumbers", nil)
+	if err != nil {
+		t.Fatal("CallList failed:", err)
+	}
+	defer r.Close()
+	var nums []int
+	for {
+		var n int
+		if err = r.Next(&n); err != nil {
+			break
+		}
+		nums = append(nums, n)
+	}
+	if err != io.EOF || fmtInts(nums) != "[1 2 3]" {
+		t.Fatal("unexpected numbers:", nums, err)
+	}
+}
+
+func TestListReaderNegotiated(t *testing.T) {
+
+	router := &restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{Repl: rpcutil.NegotiateRepl}),
+		Mux:     restrpc.NewServeMux(),
+	}
+	svr := httptest.NewServer(router.Register(new(listService)))
+	defer svr.Close()
+
+	c := &restclient.Client{BaseURL: svr.URL}
+	ctx := context.Background()
+
+	r, err := c.CallList(ctx, "GetItems_", nil, "3") // accepts only NDJSON
+	if err != nil {
+		t.Fatal("CallList failed:", err)
+	}
+	if items, err := readList(r); err != nil || fmtInts(items) != "[1 2 3]" {
+		t.Fatal("unexpected items:", items, err)
+	}
+
+	r, err = c.CallList(ctx, "GetItems_", nil, "fail")
+	if err != nil {
+		t.Fatal("CallList failed:", err)
+	}
+	if items, err := readList(r); fmtInts(items) != "[1 2]" || err == nil || err.Error() != "backend unavailable" {
+		t.Fatal("unexpected items:", items, err)
+	}
+
+	_, err = c.CallList(ctx, "GetItems_", nil, "x")
+	if e, ok := err.(*httputil.ErrorInfo); !ok || e.Code != 500 { // the error of the method, not 406
+		t.Fatal("CallList of an invalid list:", err)
+	}
+}
+
+func fmtInts(v []int) string {
+
+	s := "["
+	for i, n := range v {
+		if i > 0 {
+			s += " "
+		}
+		s += strconv.Itoa(n)
+	}
+	return s + "]"
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,330 @@
+package restrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+)
+
+/* ---------------------------------------------------------------------------
+
+Batch 在一个请求中执行多个操作，每个操作是同一个 Mux 上的一个路由，在进程内分发：
+
+	mux := router.Register(service)
+	mux.Handle("POST /batch", &restrpc.Batch{Mux: mux})
+
+	POST /batch
+	Content-Type: application/x-www-form-urlencoded
+
+	op=/stat/<EncodedEntry>&op=/delete/<EncodedEntry>&op=GET /buckets/<Bucket>
+
+- op 是 "[METHOD ]PATH[?QUERY]"，METHOD 默认为 Batch.Method(默认为 POST)。
+- 每个操作的请求带有 batch 请求的 header(如 Authorization)和 Context，所以操作的 Env 会以
+  batch 请求的身份鉴权。batch 请求不能嵌套。
+- 回复按 op 的顺序排列的 [{"code": CODE, "data": DATA}, ...]，CODE 为操作回复的状态码，DATA 为
+  操作回复的 JSON(失败时即 {"error": ..., "errno": ...})。如果所有操作都成功，状态码为 200，否则
+  为 298(StatusPartialFailure)。
+- 操作的回复缓存在内存中，必须是有限的：流式的回复(Server-Sent Events、NDJSON)直接失败(406)，
+  回复超过 Batch.MaxReplySize 时失败(500)，操作超过 Batch.OpTimeout 时失败(504)。失败时操作的
+  Context 被取消，handler 随之返回。
+
+也可以在服务方法中调用 Batch.Do，例如先由方法的 Env 鉴权，并把它的 Context 传给各个操作：
+
+	func (p *Service) PostBatch(args *batchArgs, env *AuthEnv) (*rpcutil.Response, error) {
+		results, code, err := p.batch.Do(env.Ctx, env.Req, args.Op)
+		if err != nil {
+			return nil, err
+		}
+		return &rpcutil.Response{Code: code, Body: results}, nil
+	}
+
+// -------------------------------------------------------------------------*/
+
+// StatusPartialFailure is the status code replied by Batch if some
+// operations fail.
+const StatusPartialFailure = 298
+
+// Default limits of Batch.
+const (
+	DefaultBatchMaxOps       = 1000
+	DefaultBatchConcurrency  = 8
+	DefaultBatchMaxReplySize = 1 << 20
+	DefaultBatchOpTimeout    = time.Minute
+)
+
+var (
+	errNoBatchOps     = httputil.NewError(400, "no op in batch")
+	errTooManyOps     = httputil.NewError(400, "too many ops in batch")
+	errNestedBatch    = httputil.NewError(400, "batch can't be nested")
+	errInvalidBatchOp = httputil.NewError(400, "invalid op in batch")
+	errStreamingOp    = httputil.NewError(406, "streaming reply of op in batch")
+	errOpReplyTooLong = httputil.NewError(500, "reply of op in batch too large")
+	errOpTimeout      = httputil.NewError(504, "op in batch timed out")
+)
+
+// BatchResult is the result of an operation of a batch.
+type BatchResult struct {
+	Code int             `json:"code"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Batch is an http.Handler which runs the operations of a request as
+// sub-requests dispatched by Mux.
+type Batch struct {
+	Mux Mux
+
+	// Method is the method of operations not giving one; "POST" if empty.
+	Method string
+
+	// MaxOps limits the number of operations in a batch;
+	// DefaultBatchMaxOps if 0.
+	MaxOps int
+
+	// Concurrency limits the number of operations run concurrently;
+	// DefaultBatchConcurrency if 0.
+	Concurrency int
+
+	// MaxReplySize limits the size of the reply body of an operation;
+	// DefaultBatchMaxReplySize if 0.
+	MaxReplySize int64
+
+	// OpTimeout limits the time to run an operation; DefaultBatchOpTimeout
+	// if 0, no limit if negative.
+	OpTimeout time.Duration
+}
+
+type batchKey struct{}
+
+func (b *Batch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	err := httputil.DecodeRequest(req)
+	if err == nil {
+		err = req.ParseForm()
+	}
+	if err != nil {
+		if _, ok := err.(*httputil.ErrorInfo); !ok {
+			err = httputil.NewError(400, err.Error())
+		}
+		httputil.Error(w, err)
+		return
+	}
+
+	results, code, err := b.Do(req.Context(), req, req.Form["op"])
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	httputil.Reply(w, code, results)
+}
+
+// Do runs ops as sub-requests of req with ctx, and returns their results in
+// order, with 200 if all of them succeed or StatusPartialFailure if not.
+func (b *Batch) Do(ctx context.Context, req *http.Request, ops []string) (results []*BatchResult, code int, err error) {
+
+	maxOps := b.MaxOps
+	if maxOps <= 0 {
+		maxOps = DefaultBatchMaxOps
+	}
+	switch {
+	case ctx.Value(batchKey{}) != nil:
+		return nil, 0, errNestedBatch
+	case len(ops) == 0:
+		return nil, 0, errNoBatchOps
+	case len(ops) > maxOps:
+		return nil, 0, errTooManyOps
+	}
+
+	ctx = context.WithValue(ctx, batchKey{}, true)
+	subs := make([]*http.Request, len(ops))
+	for i, op := range ops {
+		if subs[i], err = b.newOp(ctx, req, op); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	results = make([]*BatchResult, len(subs))
+	sem := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	for i, sub := range subs {
+		wg.Add(1)
+		sem <- true
+		go func(i int, sub *http.Request) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = b.serveOp(sub)
+		}(i, sub)
+	}
+	wg.Wait()
+
+	code = 200
+	for _, ret := range results {
+		if ret.Code/100 != 2 {
+			code = StatusPartialFailure
+			break
+		}
+	}
+	return
+}
+
+// newOp creates the sub-request of an op, "[METHOD ]PATH[?QUERY]".
+func (b *Batch) newOp(ctx context.Context, req *http.Request, op string) (*http.Request, error) {
+
+	method := b.Method
+	if method == "" {
+		method = "POST"
+	}
+	if pos := strings.IndexByte(op, ' '); pos > 0 {
+		method, op = strings.ToUpper(op[:pos]), strings.TrimSpace(op[pos+1:])
+	}
+	if !strings.HasPrefix(op, "/") {
+		return nil, errInvalidBatchOp
+	}
+	sub, err := http.NewRequestWithContext(ctx, method, op, http.NoBody)
+	if err != nil {
+		return nil, errInvalidBatchOp
+	}
+
+	sub.Header = req.Header.Clone()
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding", "*"} {
+		delete(sub.Header, k)
+	}
+	sub.Host = req.Host
+	sub.RemoteAddr = req.RemoteAddr
+	sub.RequestURI = op
+	sub.TLS = req.TLS
+	return sub, nil
+}
+
+func (b *Batch) serveOp(req *http.Request) (ret *BatchResult) {
+
+	timeout := b.OpTimeout
+	if timeout == 0 {
+		timeout = DefaultBatchOpTimeout
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	maxSize := b.MaxReplySize
+	if maxSize <= 0 {
+		maxSize = DefaultBatchMaxReplySize
+	}
+	w := &opRecorder{header: make(http.Header), max: maxSize, cancel: cancel}
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic: %v [batch op: %s %s]\n%s", v, req.Method, req.RequestURI, debug.Stack())
+			ret = errorResult(httputil.ErrPanic)
+		}
+	}()
+	b.Mux.ServeHTTP(w, req)
+	if w.err == nil && ctx.Err() == context.DeadlineExceeded {
+		w.err = errOpTimeout
+	}
+	return w.result()
+}
+
+func errorResult(err error) *BatchResult {
+
+	code, errno, msg := httputil.GetErrorInfo(err)
+	data, _ := json.Marshal(&struct {
+		Err   string `json:"error"`
+		Errno int    `json:"errno,omitempty"`
+	}{msg, errno})
+	return &BatchResult{Code: code, Data: data}
+}
+
+// ---------------------------------------------------------------------------
+
+// opRecorder records the response of an operation. It fails the operation
+// and cancels it if the reply is streaming or too large.
+type opRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+	max    int64
+	cancel context.CancelFunc
+	err    error
+}
+
+func (w *opRecorder) fail(err error) {
+
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *opRecorder) Header() http.Header {
+
+	return w.header
+}
+
+func (w *opRecorder) WriteHeader(code int) {
+
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	switch httputil.MediaType(w.header.Get("Content-Type")) {
+	case "text/event-stream", httputil.NDJSONMediaType:
+		w.fail(errStreamingOp)
+	}
+}
+
+func (w *opRecorder) Write(p []byte) (int, error) {
+
+	w.WriteHeader(200)
+	if w.err == nil && int64(w.body.Len()+len(p)) > w.max {
+		w.fail(errOpReplyTooLong)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.body.Write(p)
+}
+
+// result returns the status code and the body (as is in JSON, or a JSON
+// string if not) recorded, or the error failing the operation.
+func (w *opRecorder) result() *BatchResult {
+
+	if w.err != nil {
+		return errorResult(w.err)
+	}
+	ret := &BatchResult{Code: w.code}
+	if ret.Code == 0 {
+		ret.Code = 200
+	}
+	body := w.body.Bytes()
+	if len(body) == 0 {
+		return ret
+	}
+	mediaType := httputil.MediaType(w.header.Get("Content-Type"))
+	if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && json.Valid(body) {
+		ret.Data = body
+	} else {
+		ret.Data, _ = json.Marshal(string(body))
+	}
+	return ret
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,148 @@
+package restrpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+)
+
+// ---------------------------------------------------------------------------
+
+type batchUserKey struct{}
+
+type batchEnv struct {
+	restrpc.Env
+}
+
+func (p *batchEnv) OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error {
+	p.Env.OpenEnv(rcvr, w, req)
+	if user, ok := p.Ctx.Value(batchUserKey{}).(string); ok { // authorized by the batch
+		p.Ctx = context.WithValue(p.Ctx, batchUserKey{}, user+"(batch)")
+		return nil
+	}
+	if req.Header.Get("Authorization") != "token" {
+		return httputil.NewError(401, "bad token")
+	}
+	p.Ctx = context.WithValue(p.Ctx, batchUserKey{}, "user1")
+	return nil
+}
+
+type statRet struct {
+	Name string `json:"name"`
+	User string `json:"user"`
+}
+
+type batchArgs struct {
+	Op []string `json:"op"`
+}
+
+type batchService struct {
+	batch *restrpc.Batch
+}
+
+func (p *batchService) PostStat_(env *batchEnv) (ret *statRet, err error) {
+	if env.Args[0] == "missing" {
+		return nil, httputil.NewErrorEx(612, 612, "no such file or directory")
+	}
+	user, _ := env.Ctx.Value(batchUserKey{}).(string)
+	return &statRet{Name: env.Args[0], User: user}, nil
+}
+
+func (p *batchService) DeleteFiles_(env *batchEnv) error {
+	return nil
+}
+
+func (p *batchService) GetEvents(env *batchEnv) (ret <-chan rpcutil.Event, err error) {
+	ch := make(chan rpcutil.Event)
+	go func() {
+		<-env.Ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (p *batchService) GetNumbers(env *batchEnv) (ret rpcutil.ListIterator, err error) {
+	i := 0
+	return rpcutil.ListFunc(func() (interface{}, error) { // endless
+		i++
+		return i, nil
+	}), nil
+}
+
+func (p *batchService) GetSlow(env *batchEnv) error {
+	<-env.Ctx.Done()
+	return env.Ctx.Err()
+}
+
+func (p *batchService) PostBatch2(args *batchArgs, env *batchEnv) (*rpcutil.Response, error) {
+	results, code, err := p.batch.Do(env.Ctx, env.Req, args.Op)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcutil.Response{Code: code, Body: results}, nil
+}
+
+func TestBatch(t *testing.T) {
+
+	service := new(batchService)
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(service)
+	service.batch = &restrpc.Batch{Mux: mux, MaxOps: 3, Concurrency: 2, MaxReplySize: 100, OpTimeout: 100 * time.Millisecond}
+	mux.Handle("POST /batch", service.batch)
+	mux.Handle("POST /panic", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("oops")
+	}))
+
+	cases := []struct {
+		path string
+		auth string
+		ops  []string
+		code int
+		resp string
+	}{
+		{"/batch", "token", []string{"/stat/a", "/stat/missing", "DELETE /files/a"}, 298,
+			`[{"code":200,"data":{"name":"a","user":"user1"}},` +
+				`{"code":612,"data":{"error":"no such file or directory","errno":612}},{"code":200,"data":{}}]`},
+		{"/batch", "token", []string{"/stat/a", "get /stat/b"}, 298,
+			`[{"code":200,"data":{"name":"a","user":"user1"}},{"code":405,"data":{"error":"Method Not Allowed"}}]`},
+		{"/batch", "", []string{"/stat/a", "/nothing"}, 298,
+			`[{"code":401,"data":{"error":"bad token"}},{"code":404,"data":"404 page not found\n"}]`},
+		{"/batch", "token", []string{"/stat/a?x=1", "/stat/b"}, 200,
+			`[{"code":200,"data":{"name":"a","user":"user1"}},{"code":200,"data":{"name":"b","user":"user1"}}]`},
+		{"/batch", "token", []string{"/panic"}, 298,
+			`[{"code":500,"data":{"error":"internal server error"}}]`},
+		{"/batch", "token", []string{"/batch"}, 298,
+			`[{"code":400,"data":{"error":"batch can't be nested"}}]`},
+		{"/batch", "token", nil, 400, `{"error":"no op in batch"}`},
+		{"/batch", "token", []string{"/stat/a", "/stat/b", "/stat/c", "/stat/d"}, 400, `{"error":"too many ops in batch"}`},
+		{"/batch", "token", []string{"stat/a"}, 400, `{"error":"invalid op in batch"}`},
+		{"/batch2", "token", []string{"/stat/a"}, 200, `[{"code":200,"data":{"name":"a","user":"user1(batch)"}}]`},
+		{"/batch2", "", []string{"/stat/a"}, 401, `{"error":"bad token"}`},
+		{"/batch", "token", []string{"GET /events", "GET /numbers", "GET /slow"}, 298,
+			`[{"code":406,"data":{"error":"streaming reply of op in batch"}},` +
+				`{"code":500,"data":{"error":"reply of op in batch too large"}},{"code":504,"data":{"error":"op in batch timed out"}}]`},
+	}
+	for i, c := range cases {
+		body := url.Values{"op": c.ops}.Encode()
+		req := httptest.NewRequest("POST", c.path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal(i, "unexpected resp:", w.Code, w.Body.String(), "expected:", c.code, c.resp)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
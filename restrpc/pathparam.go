@@ -0,0 +1,172 @@
+package restrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/qiniu/http/formutil"
+	"github.com/qiniu/http/httputil"
+)
+
+/* ---------------------------------------------------------------------------
+
+路径参数
+
+Pattern 中除了匿名的 * 之外，还可以使用命名的路径参数，并可以给参数加上类型或正则约束：
+
+	GET /buckets/{bucket}/objects/{key:int}
+	GET /users/{name:[a-z][a-z0-9]*}
+
+约束可以是 int、uint，或者一个正则表达式(自动加上 ^ 和 $，不能包含 /)。约束不满足时返回 400，
+错误信息中给出不满足约束的参数。如果设置了 ServeMux.FallThrough，约束不满足时该路由不匹配，
+继续尝试其他路由，都不匹配时返回 404：
+
+	GET /users/{id:int}
+	GET /users/{id}/profile
+	GET /users/**              // 匹配 /users/abc
+
+约束不参与路由的区分：同一方法下仅参数名或约束不同的 Pattern(如 GET /users/{id:int} 与
+GET /users/{name})是重复注册，和 net/http.ServeMux 一样会 panic。
+
+Pattern 的最后一段可以是 ** 或 {name...}，它匹配剩余的所有(至少一段)路径，例如对象存储的 key：
+
+	GET /buckets/{bucket}/objects/{key...}
+
+对于 GET /buckets/b1/objects/a/b%2Fc.jpg，key 为 "a/b/c.jpg"。路径按 / 分段后每一段分别做 URL 解码，
+因此 %2F 不会把一段拆成两段，但它在 catch-all 参数中同样被解码为 /。
+
+按方法名约定时，Router.CatchAll 为 true 时以三个分隔符结尾表示 catch-all，例如 GetBuckets_Objects___
+的 Pattern 为 {"Get", "Buckets", "*", "Objects", "**"}。CatchAll 默认为 false，以兼容已有的方法名：
+此时 GetBuckets_Objects___ 仍为 {"Get", "Buckets", "*", "Objects", "*", "*", "*"}。
+
+所有路径参数(包括命名参数)仍按顺序放在 Env.Args 中。命名参数还可以通过 PathParams 获取，
+或者通过 `path` 标签绑定到 args 中：
+
+	type Args struct {
+		Bucket string `path:"bucket"`
+		Key    int    `path:"key"`
+		Limit  int    `json:"limit"`
+	}
+
+// -------------------------------------------------------------------------*/
+
+type pathParam struct {
+	name string
+	re   *regexp.Regexp
+}
+
+var typeConstraints = map[string]string{
+	"int":  `[+-]?[0-9]+`,
+	"uint": `[0-9]+`,
+}
+
+func isWild(seg string) bool {
+
+	return seg == "*" || seg == "**" || (strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"))
+}
+
+// isCatchAll reports whether seg is a catch-all segment: "**" or "{name...}".
+func isCatchAll(seg string) bool {
+
+	return seg == "**" || (strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}"))
+}
+
+// parsePathParam parses a wildcard segment: "*", "**", "{name}",
+// "{name:constraint}" or "{name...}".
+func parsePathParam(seg string) (*pathParam, error) {
+
+	if seg == "*" || seg == "**" {
+		return nil, nil
+	}
+	if isCatchAll(seg) {
+		if name := seg[1 : len(seg)-4]; name != "" {
+			return &pathParam{name: name}, nil
+		}
+		return nil, errors.New("path parameter has no name: " + seg)
+	}
+	name, constraint := seg[1:len(seg)-1], ""
+	if pos := strings.Index(name, ":"); pos >= 0 {
+		name, constraint = name[:pos], name[pos+1:]
+	}
+	if name == "" {
+		return nil, errors.New("path parameter has no name: " + seg)
+	}
+	p := &pathParam{name: name}
+	if constraint != "" {
+		if expr, ok := typeConstraints[constraint]; ok {
+			constraint = expr
+		}
+		re, err := regexp.Compile("^(?:" + constraint + ")$")
+		if err != nil {
+			return nil, errors.New("invalid path parameter constraint: " + seg)
+		}
+		p.re = re
+	}
+	return p, nil
+}
+
+func parsePathParams(pattern Pattern) (params []*pathParam, named bool) {
+
+	for _, seg := range pattern[1:] {
+		if isWild(seg) {
+			p, err := parsePathParam(seg)
+			if err != nil {
+				panic("restrpc: " + err.Error())
+			}
+			params = append(params, p)
+			named = named || p != nil
+		}
+	}
+	return
+}
+
+// bindPathParams checks args against params, and returns the named ones.
+func bindPathParams(params []*pathParam, args []string) (url.Values, error) {
+
+	values := make(url.Values)
+	for i, p := range params {
+		if p == nil {
+			continue
+		}
+		if p.re != nil && !p.re.MatchString(args[i]) {
+			return nil, httputil.NewError(400, "invalid path parameter `"+p.name+"`: "+args[i])
+		}
+		values[p.name] = append(values[p.name], args[i])
+	}
+	return values, nil
+}
+
+// ---------------------------------------------------------------------------
+
+type pathParamsKey struct{}
+
+func withPathParams(req *http.Request, params url.Values) *http.Request {
+
+	return req.WithContext(context.WithValue(req.Context(), pathParamsKey{}, params))
+}
+
+// PathParams returns the named path parameters of a request dispatched by
+// ServeMux, eg. {"bucket": {"b1"}} for pattern "GET /buckets/{bucket}".
+func PathParams(req *http.Request) url.Values {
+
+	params, _ := req.Context().Value(pathParamsKey{}).(url.Values)
+	return params
+}
+
+// bindPath binds the named path parameters of req to the fields of args
+// with `path` tags.
+func bindPath(args reflect.Value, req *http.Request) error {
+
+	params := PathParams(req)
+	if len(params) == 0 {
+		return nil
+	}
+	return formutil.ParseValue(args, params, "path")
+}
+
+// ---------------------------------------------------------------------------
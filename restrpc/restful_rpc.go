@@ -1,16 +1,17 @@
 package restrpc
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
-	"strings"
 	"syscall"
 
 	"github.com/qiniu/http/formutil"
 	"github.com/qiniu/http/hfac"
+	"github.com/qiniu/http/httputil"
 	"github.com/qiniu/http/rpcutil"
 )
 
@@ -21,6 +22,7 @@ type Env struct {
 	W    http.ResponseWriter
 	Req  *http.Request
 	Args []string
+	Ctx  context.Context
 }
 
 // OpenEnv init the Env instance.
@@ -28,9 +30,17 @@ func (p *Env) OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Reques
 	p.W = *w
 	p.Req = req
 	p.Args = req.Header["*"]
+	p.Ctx = req.Context()
 	return nil
 }
 
+// Context returns the Context passed to the handler method. It is derived
+// from the request's Context, and an embedding Env may replace it in its
+// OpenEnv (eg. p.Ctx = context.WithValue(p.Ctx, key, val)).
+func (p *Env) Context() context.Context {
+	return p.Ctx
+}
+
 // CloseEnv cleanup Env.
 func (p *Env) CloseEnv() {
 }
@@ -50,7 +60,8 @@ allow POST:
 /foo/<cmd>/bar		func (rcvr *XXXX) PostFoo_Bar([Arguments])([Return-Info])
 /foo/<cmd>/bar/<cmd>	func (rcvr *XXXX) PostFoo_Bar_([Arguments])([Return-Info])
 
-其余的 Method(GET/PUT/DELETE) 均只需要将函数名前的前缀从 Get 改为 Get/Put/Delete 既可。
+其余的 Method(GET/PUT/DELETE/PATCH/HEAD/OPTIONS) 均只需要将函数名前的前缀从 Post 改为
+Get/Put/Delete/Patch/Head/Options 既可。
 
 2. 参数解析
 
@@ -79,28 +90,87 @@ allow POST:
 		FormParam2: "FORM_PARAM2",
 	}
 
+请求的 body 也可以是 JSON，或其他注册了 Codec 的格式(如 XML，参见 httputil.RegisterCodec)，
+按 Content-Type 选择 Codec 解析；未注册的 Content-Type 返回 415。带 Content-Encoding(如 gzip)
+的 body 先按 httputil.DecodeRequest 解压。ReqBody 为 io.ReadCloser 或 []byte 时，方法得到的是
+原始的 body(不解压，也不受 httputil.MaxDecodedBodySize 限制)，需要时可以自己调用 DecodeRequest。
+
+3. 参数检查
+
+无论请求是 form 还是 JSON，解析之后都会按 `validate` 标签检查 args(参见 formutil.Validate)，
+包括绑定的路径参数与 ReqBody，不合法的请求返回 400，错误信息中列出所有不合法的字段。`validate`
+标签在创建 handler 时检查(参见 formutil.CheckTags)，错误的标签使 Router.Register 失败。
+
 // -------------------------------------------------------------------------*/
 
-func isJSONCall(req *http.Request) bool {
+// decodeBody decodes the request body into v by the codec of its Content-Type
+// (see httputil.CodecOf), eg. JSON, including the structured syntax suffix
+// "+json". It returns ok == false if the body is a form or the request has no
+// Content-Type, and httputil.ErrUnsupportedMediaType if the Content-Type of a
+// non-empty body has no codec.
+func decodeBody(v interface{}, req *http.Request) (ok bool, err error) {
+
+	mediaType := httputil.MediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "", "application/x-www-form-urlencoded", "multipart/form-data":
+		return false, nil
+	}
 
-	ct := req.Header.Get("Content-Type")
-	return ct == "application/json" || strings.HasPrefix(ct, "application/json;")
+	codec := httputil.CodecOf(mediaType)
+	if codec == nil {
+		if req.ContentLength == 0 {
+			return false, nil
+		}
+		return true, httputil.ErrUnsupportedMediaType
+	}
+	if req.ContentLength == 0 {
+		return true, nil
+	}
+	if codec == httputil.JSONCodec {
+		return true, json.NewDecoder(req.Body).Decode(v)
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return true, err
+	}
+	return true, codec.Unmarshal(b, v)
 }
 
 func parseReqDefault(ret reflect.Value, req *http.Request) error {
 
-	if isJSONCall(req) {
-		if req.ContentLength == 0 {
-			return nil
+	if err := httputil.DecodeRequest(req); err != nil {
+		return err
+	}
+	ok, err := decodeBody(ret.Interface(), req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err = req.ParseForm(); err != nil {
+			return err
+		}
+		if err = formutil.ParseValue(ret, req.Form, "json"); err != nil {
+			return err
 		}
-		return json.NewDecoder(req.Body).Decode(ret.Interface())
 	}
+	if err = bindPath(ret, req); err != nil {
+		return err
+	}
+	return validate(ret)
+}
 
-	err := req.ParseForm()
+// validate checks the parsed arguments by their `validate` tags, see
+// formutil.Validate.
+func validate(ret reflect.Value) error {
+
+	if ret.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	err := formutil.ValidateValue(ret, "json")
 	if err != nil {
-		return err
+		return httputil.NewError(400, err.Error())
 	}
-	return formutil.ParseValue(ret, req.Form, "json")
+	return nil
 }
 
 /* ---------------------------------------------------------------------------
@@ -138,20 +208,60 @@ func parseReqDefault(ret reflect.Value, req *http.Request) error {
 
 func parseReqWithBody(ret reflect.Value, req *http.Request) error {
 
-	if isJSONCall(req) {
-		ret = ret.Elem().FieldByName("ReqBody").Addr()
-		if req.ContentLength == 0 {
-			return nil
-		}
-		return json.NewDecoder(req.Body).Decode(ret.Interface())
+	if err := httputil.DecodeRequest(req); err != nil {
+		return err
+	}
+	body := ret.Elem().FieldByName("ReqBody").Addr()
+	ok, err := decodeBody(body.Interface(), req)
+	if err != nil {
+		return err
 	}
-	return syscall.EINVAL
+	if !ok {
+		return syscall.EINVAL
+	}
+	if err = bindPath(ret, req); err != nil {
+		return err
+	}
+	return validate(ret) // including the body, see formutil.Validate
+}
+
+/* ---------------------------------------------------------------------------
+
+PATCH 请求的 body 可以是 JSON Merge Patch(RFC 7396, application/merge-patch+json)
+或 JSON Patch(RFC 6902, application/json-patch+json)。
+
+JSON Merge Patch 按 JSON 解析。由于 null 表示删除字段，通常用 ReqBody map[string]interface{}
+来接收，以区分字段不存在与字段为 null：
+
+	type Args struct {
+		ReqBody map[string]interface{}
+	}
+
+	func (rcvr *XXXX) PatchUsers_(args *Args, env *restrpc.Env) (err error)
+
+JSON Patch 是一个操作的数组，用 ReqBody []restrpc.JSONPatchOp 接收：
+
+	type Args struct {
+		ReqBody []restrpc.JSONPatchOp
+	}
+
+// -------------------------------------------------------------------------*/
+
+// JSONPatchOp is an operation of a JSON Patch (RFC 6902) document.
+type JSONPatchOp struct {
+	Op    string          `json:"op"` // add, remove, replace, move, copy or test
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
 }
 
 func parseReqWithReader(ret reflect.Value, req *http.Request) error {
 
 	ret.Elem().FieldByName("ReqBody").Set(reflect.ValueOf(req.Body))
-	return nil
+	if err := bindPath(ret, req); err != nil {
+		return err
+	}
+	return validate(ret)
 }
 
 func parseReqWithBytes(ret reflect.Value, req *http.Request) error {
@@ -161,7 +271,10 @@ func parseReqWithBytes(ret reflect.Value, req *http.Request) error {
 		return err
 	}
 	ret.Elem().FieldByName("ReqBody").Set(reflect.ValueOf(b))
-	return nil
+	if err = bindPath(ret, req); err != nil {
+		return err
+	}
+	return validate(ret)
 }
 
 // ---------------------------------------------------------------------------
@@ -191,14 +304,43 @@ func selParseReq(reqType reflect.Type) func(ret reflect.Value, req *http.Request
 
 // ---------------------------------------------------------------------------
 
-var newHandler = rpcutil.HandlerCreator{SelParseReq: selParseReq}.New
+// NewFactory creates a HandlerFactory whose handlers are created by creator,
+// eg. to install interceptors or a Replier for a Router. If neither
+// creator.ParseReq nor creator.SelParseReq is set, the restrpc request
+// parsers are used, and creating a handler fails with a *formutil.TagError
+// if its args have a malformed `validate` tag.
+func NewFactory(creator rpcutil.HandlerCreator) hfac.HandlerFactory {
+
+	newHandler := creator.New
+	if creator.ParseReq == nil && creator.SelParseReq == nil {
+		creator.SelParseReq = selParseReq
+		newHandler = func(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+			h, err := creator.New(rcvr, method)
+			if err != nil {
+				return nil, err
+			}
+			if spec, _ := creator.Spec(method); spec.ReqType != nil {
+				if err = formutil.CheckTags(spec.ReqType); err != nil { // see validate
+					return nil, err
+				}
+			}
+			return h, nil
+		}
+	}
+	newWsHandler := hfac.Options{OnPanic: creator.OnPanic}.NewWsHandler
+	return hfac.HandlerFactory{
+		{Prefix: "Post", Creator: newHandler},
+		{Prefix: "Put", Creator: newHandler},
+		{Prefix: "Delete", Creator: newHandler},
+		{Prefix: "Get", Creator: newHandler},
+		{Prefix: "Patch", Creator: newHandler},
+		{Prefix: "Head", Creator: newHandler},
+		{Prefix: "Options", Creator: newHandler},
+		{Prefix: "Ws", Creator: newWsHandler},
+	}
+}
 
 // Factory is a HandlerFactory.
-var Factory = hfac.HandlerFactory{
-	{Prefix: "Post", Creator: newHandler},
-	{Prefix: "Put", Creator: newHandler},
-	{Prefix: "Delete", Creator: newHandler},
-	{Prefix: "Get", Creator: newHandler},
-}
+var Factory = NewFactory(rpcutil.HandlerCreator{})
 
 // ---------------------------------------------------------------------------
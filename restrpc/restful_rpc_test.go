@@ -0,0 +1,1151 @@
+package restrpc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/http/formutil"
+	"github.com/qiniu/http/hfac"
+	"github.com/qiniu/http/httputil"
+	"github.com/qiniu/http/restrpc"
+	"github.com/qiniu/http/rpcutil"
+	"github.com/qiniu/http/websocket"
+)
+
+// ---------------------------------------------------------------------------
+
+type ctxKey struct{}
+
+type ctxEnv struct {
+	restrpc.Env
+}
+
+func (p *ctxEnv) OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error {
+	p.Env.OpenEnv(rcvr, w, req)
+	p.Ctx = context.WithValue(p.Ctx, ctxKey{}, req.Header.Get("X-Reqid"))
+	return nil
+}
+
+type ctxService struct {
+	reqid string
+	err   error
+}
+
+func (p *ctxService) GetReqid(ctx context.Context, env *ctxEnv) (err error) {
+	p.reqid, _ = ctx.Value(ctxKey{}).(string)
+	p.err = ctx.Err()
+	return
+}
+
+func TestHandlerContext(t *testing.T) {
+
+	svr := new(ctxService)
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(svr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/reqid", nil).WithContext(ctx)
+	req.Header.Set("X-Reqid", "abc")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if svr.reqid != "abc" || svr.err != nil {
+		t.Fatal("unexpected ctx:", svr.reqid, svr.err)
+	}
+
+	cancel()
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if svr.err != context.Canceled {
+		t.Fatal("ctx not canceled:", svr.err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type panicService struct{}
+
+func (p *panicService) PostPanic_(env *restrpc.Env) error {
+	var m map[string]int
+	m[env.Args[0]] = 1
+	return nil
+}
+
+func (p *panicService) PostHalf(env *restrpc.Env) error {
+	env.W.WriteHeader(200)
+	env.W.Write([]byte("half"))
+	panic("oops")
+}
+
+func TestHandlerPanic(t *testing.T) {
+
+	var info *httputil.PanicInfo
+	newHandler := rpcutil.HandlerCreator{
+		Repl:    rpcutil.NegotiateRepl,
+		OnPanic: func(req *http.Request, pi *httputil.PanicInfo) { info = pi },
+	}.New
+	router := restrpc.Router{
+		Factory: hfac.HandlerFactory{{Prefix: "Post", Creator: newHandler}},
+		Mux:     restrpc.NewServeMux(),
+	}
+	mux := router.Register(new(panicService))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/panic/abc", nil))
+	if w.Code != 500 || w.Body.String() != `{"error":"internal server error"}` {
+		t.Fatal("unexpected resp:", w.Code, w.Body.String())
+	}
+	if info == nil || info.Method != "PostPanic_" || info.Route != "Post /Panic/*" || len(info.Stack) == 0 {
+		t.Fatal("unexpected panic info:", info)
+	}
+
+	req := httptest.NewRequest("POST", "/panic/abc", nil)
+	req.Header.Set("Accept", "application/problem+json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req) // replied by the negotiated Replier
+	if w.Code != 500 || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatal("unexpected negotiated resp:", w.Code, w.Header(), w.Body.String())
+	}
+
+	info = nil
+	w = httptest.NewRecorder()
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatal("the response isn't aborted:", v)
+			}
+		}()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/half", nil))
+	}()
+	if w.Code != 200 || w.Body.String() != "half" || info == nil || info.Method != "PostHalf" {
+		t.Fatal("unexpected resp after the response started:", w.Code, w.Body.String(), info)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type echoArgs struct {
+	A string `json:"a"`
+}
+
+type echoService struct{}
+
+func (p *echoService) PostEcho(args *echoArgs, env *restrpc.Env) (ret echoArgs, err error) {
+	return *args, nil
+}
+
+func (p *echoService) PostNop(env *restrpc.Env) error {
+	return nil
+}
+
+type rawBytesArgs struct {
+	ReqBody []byte
+}
+
+type rawReaderArgs struct {
+	ReqBody io.ReadCloser
+}
+
+func (p *echoService) PutBytes(args *rawBytesArgs, env *restrpc.Env) {
+	env.W.Write(args.ReqBody)
+}
+
+func (p *echoService) PutReader(args *rawReaderArgs, env *restrpc.Env) {
+	io.Copy(env.W, args.ReqBody)
+}
+
+func TestInterceptors(t *testing.T) {
+
+	var trace []string
+	traceOf := func(name string) rpcutil.Interceptor {
+		return func(inv *rpcutil.Invocation, next rpcutil.Invoker) (interface{}, error) {
+			trace = append(trace, name+">"+inv.Method)
+			ret, err := next(inv)
+			trace = append(trace, name+"<"+inv.Method)
+			return ret, err
+		}
+	}
+	auth := func(inv *rpcutil.Invocation, next rpcutil.Invoker) (interface{}, error) {
+		if args, ok := inv.Args.(*echoArgs); ok && args.A == "deny" {
+			return nil, httputil.NewError(403, "denied")
+		}
+		return next(inv)
+	}
+	rewrite := func(inv *rpcutil.Invocation, next rpcutil.Invoker) (interface{}, error) {
+		ret, err := next(inv)
+		return map[string]interface{}{"data": ret}, err
+	}
+
+	router := restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{
+			Interceptors: []rpcutil.Interceptor{traceOf("a"), auth, traceOf("b")},
+			MethodInterceptors: map[string][]rpcutil.Interceptor{
+				"PostEcho": {rewrite},
+			},
+		}),
+		Mux: restrpc.NewServeMux(),
+	}
+	mux := router.Register(new(echoService))
+
+	cases := []struct {
+		path, body string
+		code       int
+		resp       string
+		trace      string
+	}{
+		{"/echo", "a=1", 200, `{"data":{"a":"1"}}`, "a>PostEcho b>PostEcho b<PostEcho a<PostEcho"},
+		{"/echo", "a=deny", 403, `{"error":"denied"}`, "a>PostEcho a<PostEcho"},
+		{"/nop", "", 200, `{}`, "a>PostNop b>PostNop b<PostNop a<PostNop"},
+	}
+	for _, c := range cases {
+		trace = nil
+		req := httptest.NewRequest("POST", c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, c.body, w.Code, w.Body.String())
+		}
+		if strings.Join(trace, " ") != c.trace {
+			t.Fatal("unexpected trace:", c.path, c.body, trace)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type bucketArgs struct {
+	Bucket string `json:"bucket" validate:"required,min=3"`
+	Mode   string `json:"mode" validate:"omitempty,oneof=public private"`
+}
+
+type policyArgs struct {
+	Bucket  string `path:"bucket" json:"bucket" validate:"min=3"`
+	ReqBody struct {
+		Mode string `json:"mode" validate:"required,oneof=public private"`
+	}
+}
+
+type validateService struct{}
+
+func (p *validateService) PostBuckets(args *bucketArgs) (ret bucketArgs, err error) {
+	return *args, nil
+}
+
+func (p *validateService) PutPolicy(args *policyArgs) error {
+	return nil
+}
+
+type badTagArgs struct {
+	Limit int `json:"limit" validate:"max=many"`
+}
+
+type badTagService struct{}
+
+func (p *badTagService) GetItems(args *badTagArgs) error {
+	return nil
+}
+
+func TestValidateArgs(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(new(validateService))
+	router.Register(new(validateService), [][2]string{{"PUT /buckets/{bucket}/policy", "PutPolicy"}})
+
+	cases := []struct {
+		method, path string
+		ctype, body  string
+		code         int
+		resp         string
+	}{
+		{"POST", "/buckets", "application/x-www-form-urlencoded", "bucket=abc&mode=public", 200, `{"bucket":"abc","mode":"public"}`},
+		{"POST", "/buckets", "application/x-www-form-urlencoded", "bucket=ab&mode=x", 400, `{"error":"invalid arguments: bucket: min=3; mode: oneof=public private"}`},
+		{"POST", "/buckets", "application/json", `{"bucket":"abc"}`, 200, `{"bucket":"abc","mode":""}`},
+		{"POST", "/buckets", "application/json", `{"mode":"private"}`, 400, `{"error":"invalid arguments: bucket: required"}`},
+		{"PUT", "/buckets/abc/policy", "application/json", `{"mode":"public"}`, 200, `{}`},
+		{"PUT", "/buckets/ab/policy", "application/json", `{}`, 400, `{"error":"invalid arguments: bucket: min=3; mode: required"}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.ctype)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, c.body, w.Code, w.Body.String())
+		}
+	}
+
+	rcvr := reflect.ValueOf(new(badTagService))
+	method, _ := rcvr.Type().MethodByName("GetItems")
+	if _, _, err := restrpc.Factory.Create(rcvr, method); err == nil {
+		t.Fatal("malformed validate tags not found when creating the handler")
+	} else if _, ok := err.(*formutil.TagError); !ok {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type objectArgs struct {
+	Bucket string `path:"bucket" json:"bucket"`
+	Key    int    `path:"key" json:"key"`
+	Limit  int    `json:"limit"`
+}
+
+type objectRet struct {
+	objectArgs
+	Args []string `json:"args"`
+}
+
+type objectService struct{}
+
+func (p *objectService) GetObject(args *objectArgs, env *restrpc.Env) (ret objectRet, err error) {
+	return objectRet{*args, env.Args}, nil
+}
+
+func TestPathParams(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(new(objectService), [][2]string{
+		{"GET /buckets/{bucket}/objects/{key:int}", "GetObject"},
+		{"GET /v2/{bucket:[a-z]+}/*", "GetObject"},
+	})
+
+	cases := []struct {
+		path string
+		code int
+		resp string
+	}{
+		{"/buckets/b1/objects/12?limit=3", 200, `{"bucket":"b1","key":12,"limit":3,"args":["b1","12"]}`},
+		{"/buckets/b1/objects/abc", 400, `{"error":"invalid path parameter ` + "`key`" + `: abc"}`},
+		{"/v2/bkt/12", 200, `{"bucket":"bkt","key":0,"limit":0,"args":["bkt","12"]}`},
+		{"/v2/b1/12", 400, `{"error":"invalid path parameter ` + "`bucket`" + `: b1"}`},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type keyArgs struct {
+	Key string `path:"key"`
+}
+
+type keyService struct{}
+
+func (p *keyService) GetBuckets_Objects___(env *restrpc.Env) (ret []string, err error) {
+	return env.Args, nil
+}
+
+func (p *keyService) GetKey(args *keyArgs) (ret string, err error) {
+	return args.Key, nil
+}
+
+func TestCatchAll(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux(), CatchAll: true}
+	mux := router.Register(new(keyService))
+	router.Register(new(keyService), [][2]string{{"GET /keys/{key...}", "GetKey"}})
+
+	compat := restrpc.Router{Mux: restrpc.NewServeMux()} // GetBuckets_Objects___ has three wildcards
+	compatMux := compat.Register(new(keyService))
+
+	cases := []struct {
+		mux  restrpc.Mux
+		path string
+		code int
+		resp string
+	}{
+		{mux, "/buckets/b1/objects/a/b%2Fc.jpg", 200, `["b1","a/b/c.jpg"]`},
+		{mux, "/keys/a/b%20c.jpg", 200, `"a/b c.jpg"`},
+		{compatMux, "/buckets/b1/objects/a/b/c.jpg", 200, `["b1","a","b","c.jpg"]`},
+		{compatMux, "/buckets/b1/objects/a/b/c/d.jpg", 404, "404 page not found\n"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.mux.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type mergePatchArgs struct {
+	ReqBody map[string]interface{}
+}
+
+type jsonPatchArgs struct {
+	ReqBody []restrpc.JSONPatchOp
+}
+
+type patchService struct{}
+
+func (p *patchService) PatchUsers_(args *mergePatchArgs, env *restrpc.Env) (ret map[string]interface{}, err error) {
+	return args.ReqBody, nil
+}
+
+func (p *patchService) PatchItems_(args *jsonPatchArgs, env *restrpc.Env) (ret []restrpc.JSONPatchOp, err error) {
+	return args.ReqBody, nil
+}
+
+func (p *patchService) HeadUsers_(env *restrpc.Env) error {
+	env.W.Header().Set("X-User", env.Args[0])
+	return nil
+}
+
+func (p *patchService) OptionsUsers_(env *restrpc.Env) {
+	env.W.Header().Set("Allow", "PATCH, HEAD")
+	env.W.WriteHeader(200)
+}
+
+func TestPatchMethods(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(new(patchService))
+
+	cases := []struct {
+		method, path, ctype, body string
+		code                      int
+		resp                      string
+	}{
+		{"PATCH", "/users/u1", "application/merge-patch+json", `{"name":"foo","age":null}`, 200, `{"age":null,"name":"foo"}`},
+		{"PATCH", "/items/i1", "application/json-patch+json", `[{"op":"remove","path":"/a"}]`, 200, `[{"op":"remove","path":"/a"}]`},
+		{"HEAD", "/users/u1", "", "", 200, `{}`}, // net/http drops the body
+		{"OPTIONS", "/users/u1", "", "", 200, ``},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if c.ctype != "" {
+			req.Header.Set("Content-Type", c.ctype)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.method, c.path, w.Code, w.Body.String())
+		}
+		if c.method == "HEAD" && w.Header().Get("X-User") != "u1" {
+			t.Fatal("HeadUsers_ not called")
+		}
+		if c.method == "OPTIONS" && w.Header().Get("Allow") != "PATCH, HEAD" {
+			t.Fatal("OptionsUsers_ not called")
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type csvCodec struct{}
+
+func (csvCodec) Marshal(v interface{}) ([]byte, error) {
+	ret := v.(*codecRet)
+	return []byte(ret.Name + "," + strconv.Itoa(ret.Age)), nil
+}
+
+func (csvCodec) Unmarshal(data []byte, v interface{}) error {
+	parts := strings.Split(string(data), ",")
+	args := v.(*codecArgs)
+	args.Name = parts[0]
+	args.Age, _ = strconv.Atoi(parts[1])
+	return nil
+}
+
+type codecArgs struct {
+	Name string `json:"name" xml:"name"`
+	Age  int    `json:"age" xml:"age"`
+}
+
+type codecRet struct {
+	XMLName xml.Name `json:"-" xml:"user"`
+	Name    string   `json:"name" xml:"name"`
+	Age     int      `json:"age" xml:"age"`
+}
+
+type codecService struct{}
+
+func (p *codecService) PostUsers(args *codecArgs, env *restrpc.Env) (ret *codecRet, err error) {
+	if args.Age < 0 {
+		return nil, httputil.NewError(400, "invalid age")
+	}
+	return &codecRet{Name: args.Name, Age: args.Age}, nil
+}
+
+func (p *codecService) GetAvatar(env *restrpc.Env) (ret rpcutil.Blob, err error) {
+	return rpcutil.Blob{ContentType: "image/png", Data: []byte("png")}, nil
+}
+
+func (p *codecService) GetThumbnail(env *restrpc.Env) (ret *rpcutil.Response, err error) {
+	h := http.Header{"Content-Type": {"image/png"}}
+	return &rpcutil.Response{Code: 201, Header: h, Body: strings.NewReader("thumb")}, nil
+}
+
+func TestContentNegotiation(t *testing.T) {
+
+	httputil.RegisterCodec("text/x-csv", csvCodec{})
+
+	router := restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{Repl: rpcutil.NegotiateRepl}),
+		Mux:     restrpc.NewServeMux(),
+	}
+	mux := router.Register(new(codecService))
+
+	cases := []struct {
+		ctype, accept, body string
+		code                int
+		respType, resp      string
+	}{
+		{"application/json", "", `{"name":"foo","age":1}`, 200, "application/json", `{"name":"foo","age":1}`},
+		{"application/xml", "application/xml", `<args><name>foo</name><age>2</age></args>`, 200, "application/xml", `<user><name>foo</name><age>2</age></user>`},
+		{"text/x-csv", "application/json;q=0.5, text/x-csv", `foo,3`, 200, "text/x-csv", `foo,3`},
+		{"application/json", "text/*", `{"name":"foo","age":4}`, 200, "text/xml", `<user><name>foo</name><age>4</age></user>`},
+		{"application/json", "application/xml", `{"name":"foo","age":-1}`, 400, "application/xml", `<error><message>invalid age</message></error>`},
+		{"application/json", "image/png", `{"name":"foo","age":1}`, 406, "application/json", `{"error":"not acceptable"}`},
+		{"text/csv", "", `foo,1`, 415, "application/json", `{"error":"unsupported media type"}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.ctype)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Content-Type") != c.respType || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.ctype, c.accept, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	// bodies replied as is aren't subject to negotiation
+	blobs := []struct {
+		path, accept string
+		code         int
+		resp         string
+	}{
+		{"/avatar", "image/png", 200, "png"},
+		{"/avatar", "image/webp", 200, "png"},
+		{"/thumbnail", "image/png", 201, "thumb"},
+	}
+	for _, c := range blobs {
+		req := httptest.NewRequest("GET", c.path, nil)
+		req.Header.Set("Accept", c.accept)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Content-Type") != "image/png" || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, c.accept, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type problemService struct{}
+
+func (p *problemService) PostMsgs(env *restrpc.Env) error {
+	return httputil.NewErrorEx(403, 612, "balance is 30, but that costs 50").
+		WithType("https://example.com/probs/out-of-credit", "You do not have enough credit.").
+		WithInstance("/account/12345/msgs/abc").
+		WithExtension("balance", 30)
+}
+
+func (p *problemService) GetMsgs_(env *restrpc.Env) error {
+	return httputil.NewError(404, "no such msg: "+env.Args[0])
+}
+
+func (p *problemService) GetMsgs(env *restrpc.Env) (ret []string, err error) {
+	return []string{"m2"}, nil
+}
+
+func TestProblem(t *testing.T) {
+
+	problemMux := (&restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{Repl: rpcutil.ProblemRepl}),
+		Mux:     restrpc.NewServeMux(),
+	}).Register(new(problemService))
+	negotiateMux := (&restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{Repl: rpcutil.NegotiateRepl}),
+		Mux:     restrpc.NewServeMux(),
+	}).Register(new(problemService))
+
+	cases := []struct {
+		mux                http.Handler
+		method, path, acpt string
+		code               int
+		ctype, resp        string
+	}{
+		{problemMux, "POST", "/msgs", "", 403, "application/problem+json",
+			`{"balance":30,"detail":"balance is 30, but that costs 50","errno":612,"instance":"/account/12345/msgs/abc","status":403,"title":"You do not have enough credit.","type":"https://example.com/probs/out-of-credit"}`},
+		{problemMux, "GET", "/msgs/m1", "", 404, "application/problem+json",
+			`{"detail":"no such msg: m1","status":404,"title":"Not Found","type":"about:blank"}`},
+		{negotiateMux, "GET", "/msgs/m1", "", 404, "application/json", `{"error":"no such msg: m1"}`},
+		{negotiateMux, "GET", "/msgs/m1", "application/json, application/problem+json", 404, "application/problem+json",
+			`{"detail":"no such msg: m1","status":404,"title":"Not Found","type":"about:blank"}`},
+		{negotiateMux, "GET", "/msgs/m1", "image/png, application/problem+json", 404, "application/problem+json", // errors aren't 406
+			`{"detail":"no such msg: m1","status":404,"title":"Not Found","type":"about:blank"}`},
+		{negotiateMux, "GET", "/msgs", "application/problem+json", 200, "application/json", `["m2"]`},
+		{negotiateMux, "GET", "/msgs", "application/problem+json, text/xml", 200, "text/xml", `<string>m2</string>`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.acpt != "" {
+			req.Header.Set("Accept", c.acpt)
+		}
+		w := httptest.NewRecorder()
+		c.mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Content-Type") != c.ctype || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.path, c.acpt, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+var errBucketNotFound = httputil.NewCatalog("en").Register(&httputil.CatalogEntry{
+	Errno: 612, Code: 404, Name: "BucketNotFound",
+	Messages: map[string]string{"en": "bucket %s not found", "zh-CN": "存储空间 %s 不存在"},
+})
+
+type catalogService struct{}
+
+func (p *catalogService) GetBuckets_(env *restrpc.Env) error {
+	return errBucketNotFound.New(env.Args[0])
+}
+
+func TestCatalogError(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(new(catalogService))
+
+	for lang, resp := range map[string]string{
+		"":             `{"error":"bucket b1 not found","errno":612}`,
+		"zh-CN,en;q=0": `{"error":"存储空间 b1 不存在","errno":612}`,
+	} {
+		req := httptest.NewRequest("GET", "/buckets/b1", nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 404 || w.Body.String() != resp {
+			t.Fatal("unexpected resp:", lang, w.Code, w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// ---------------------------------------------------------------------------
+
+type trackedReader struct {
+	*strings.Reader
+	closed *bool
+}
+
+func (p trackedReader) Close() error {
+
+	*p.closed = true
+	return nil
+}
+
+type streamService struct {
+	closed bool
+}
+
+func (p *streamService) GetFiles_(env *restrpc.Env) (ret io.ReadCloser, err error) {
+
+	r := strings.NewReader("content of " + env.Args[0])
+	switch env.Args[0] {
+	case "bad":
+		return trackedReader{r, &p.closed}, httputil.NewError(403, "forbidden")
+	case "partial":
+		r.Seek(8, io.SeekStart) // partly consumed
+	}
+	return trackedReader{r, &p.closed}, nil
+}
+
+func (p *streamService) GetLogs(env *restrpc.Env) (ret *rpcutil.Stream, err error) {
+
+	return &rpcutil.Stream{ContentType: "text/plain", Size: -1, Body: strings.NewReader("line1\nline2\n")}, nil
+}
+
+func (p *streamService) GetThumbnail(env *restrpc.Env) (ret rpcutil.Blob, err error) {
+
+	return rpcutil.Blob{ContentType: "image/png", Data: []byte("PNG")}, nil
+}
+
+func (p *streamService) PostJobs(env *restrpc.Env) (ret *rpcutil.Response, err error) {
+
+	return &rpcutil.Response{
+		Code:   202,
+		Header: http.Header{"Location": {"/jobs/j1"}},
+		Body:   map[string]string{"id": "j1"},
+	}, nil
+}
+
+func (p *streamService) DeleteJobs_(env *restrpc.Env) (ret rpcutil.Response, err error) {
+
+	return rpcutil.Response{Code: 204}, nil
+}
+
+func (p *streamService) GetObjects_(env *restrpc.Env) (ret *httputil.Content, err error) {
+
+	r := trackedReader{strings.NewReader("content of " + env.Args[0]), &p.closed}
+	return &httputil.Content{ContentType: "text/plain", ETag: `"e1"`, ReadSeeker: r}, nil
+}
+
+func TestContentReturn(t *testing.T) {
+
+	service := new(streamService)
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(service)
+
+	req := httptest.NewRequest("GET", "/objects/o1", nil)
+	req.Header.Set("Range", "bytes=11-")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != 206 || w.Header().Get("Content-Range") != "bytes 11-12/13" || w.Body.String() != "o1" || !service.closed {
+		t.Fatal("unexpected resp:", w.Code, w.Header(), w.Body.String(), service.closed)
+	}
+
+	req = httptest.NewRequest("GET", "/objects/o1", nil)
+	req.Header.Set("If-None-Match", `"e1"`)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Fatal("unexpected resp:", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestStreamReturns(t *testing.T) {
+
+	service := new(streamService)
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(service)
+
+	cases := []struct {
+		method, path string
+		code         int
+		ctype, clen  string
+		resp         string
+		header       string
+	}{
+		{"GET", "/files/a.txt", 200, "application/octet-stream", "16", "content of a.txt", ""},
+		{"GET", "/files/partial", 200, "application/octet-stream", "10", "of partial", ""},
+		{"GET", "/files/bad", 403, "application/json", "21", `{"error":"forbidden"}`, ""},
+		{"GET", "/logs", 200, "text/plain", "", "line1\nline2\n", ""},
+		{"GET", "/thumbnail", 200, "image/png", "3", "PNG", ""},
+		{"POST", "/jobs", 202, "application/json", "11", `{"id":"j1"}`, "/jobs/j1"},
+		{"DELETE", "/jobs/j1", 204, "", "", "", ""},
+	}
+	for _, c := range cases {
+		service.closed = false
+		req := httptest.NewRequest(c.method, c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		h := w.Header()
+		if w.Code != c.code || h.Get("Content-Type") != c.ctype || h.Get("Content-Length") != c.clen ||
+			w.Body.String() != c.resp || h.Get("Location") != c.header {
+			t.Fatal("unexpected resp:", c.path, w.Code, h, w.Body.String())
+		}
+		if strings.HasPrefix(c.path, "/files/") && !service.closed {
+			t.Fatal("body not closed:", c.path)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type createdRet struct {
+	ID string `json:"id"`
+}
+
+func (p *createdRet) StatusCode() int {
+
+	if p.ID == "" {
+		return 200
+	}
+	return 0
+}
+
+func (p *createdRet) ReplyHeader(h http.Header) {
+
+	h.Set("Location", "/items/"+p.ID)
+}
+
+type statusService struct{}
+
+func (p *statusService) PostItems(env *restrpc.Env) (ret *createdRet, err error) {
+
+	return &createdRet{ID: env.Req.FormValue("id")}, nil
+}
+
+func (p *statusService) PostItems_Touch(env *restrpc.Env) error {
+
+	return nil
+}
+
+func (p *statusService) DeleteItems_(env *restrpc.Env) error {
+
+	return nil
+}
+
+func (p *statusService) GetItems_(env *restrpc.Env) (ret map[string]string, err error) {
+
+	return map[string]string{"id": env.Args[0]}, nil
+}
+
+func (p *statusService) PostItems_Copies(env *restrpc.Env) (ret <-chan string, err error) {
+
+	ch := make(chan string, 2)
+	ch <- env.Args[0] + "-1"
+	ch <- env.Args[0] + "-2"
+	close(ch)
+	return ch, nil
+}
+
+func TestStatusCodes(t *testing.T) {
+
+	mux := (&restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{
+			StatusCodes: map[string]int{"Post": 201, "Delete": 204, "PostItems_Touch": 200},
+		}),
+		Mux: restrpc.NewServeMux(),
+	}).Register(new(statusService))
+
+	cases := []struct {
+		method, path string
+		code         int
+		location     string
+		resp         string
+	}{
+		{"POST", "/items?id=i1", 201, "/items/i1", `{"id":"i1"}`},
+		{"POST", "/items", 200, "/items/", `{"id":""}`},
+		{"POST", "/items/i1/touch", 200, "", `{}`},
+		{"DELETE", "/items/i1", 204, "", ""},
+		{"GET", "/items/i1", 200, "", `{"id":"i1"}`},
+		{"POST", "/items/i1/copies", 201, "", "[\"i1-1\",\n\"i1-2\"]\n"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Location") != c.location || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.method, c.path, w.Code, w.Header(), w.Body.String())
+		}
+		if c.code == 204 && w.Header().Get("Content-Length") != "" {
+			t.Fatal("unexpected Content-Length of 204:", w.Header())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+func gzipped(s string) *bytes.Buffer {
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return &b
+}
+
+func TestCompressedRequests(t *testing.T) {
+
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(new(echoService))
+
+	defer func(max int64) { httputil.MaxDecodedBodySize = max }(httputil.MaxDecodedBodySize)
+	httputil.MaxDecodedBodySize = 1 << 10
+	large := strings.Repeat("x", 1<<10)
+	tooLarge := `{"error":"decoded request body too large"}`
+
+	cases := []struct {
+		ctype, encoding string
+		body            io.Reader
+		code            int
+		resp            string
+	}{
+		{"application/json", "gzip", gzipped(`{"a":"json"}`), 200, `{"a":"json"}`},
+		{"application/x-www-form-urlencoded", "gzip", gzipped("a=form"), 200, `{"a":"form"}`},
+		{"application/json", "br", strings.NewReader(`xxx`), 415, `{"error":"unsupported content encoding"}`},
+		{"application/json", "gzip", strings.NewReader(`xxx`), 400, ""},
+		{"application/json", "gzip", gzipped(`{"a":"` + large + `"}`), 413, tooLarge},
+		{"application/x-www-form-urlencoded", "gzip", gzipped("a=" + large), 413, tooLarge},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/echo", c.body)
+		req.Header.Set("Content-Type", c.ctype)
+		req.Header.Set("Content-Encoding", c.encoding)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || (c.resp != "" && w.Body.String() != c.resp) {
+			t.Fatal("unexpected resp:", c.ctype, c.encoding, w.Code, w.Body.String())
+		}
+	}
+
+	// raw bodies are passed as is, neither decoded nor limited
+	raw := gzipped(large + large).Bytes()
+	for _, path := range []string{"/bytes", "/reader"} {
+		req := httptest.NewRequest("PUT", path, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 200 || !bytes.Equal(w.Body.Bytes(), raw) {
+			t.Fatal("unexpected resp:", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type eventService struct {
+	done chan bool
+}
+
+func (p *eventService) GetEvents(env *restrpc.Env) (ret <-chan rpcutil.Event, err error) {
+
+	from, _ := strconv.Atoi(rpcutil.LastEventID(env.Req))
+	ch := make(chan rpcutil.Event)
+	go func() {
+		defer close(ch)
+		for i := from + 1; i <= 3; i++ {
+			ch <- rpcutil.Event{ID: strconv.Itoa(i), Event: "progress", Data: map[string]int{"percent": i * 10}}
+		}
+		ch <- rpcutil.Event{Data: "bye\nsee you", Retry: time.Second}
+	}()
+	return ch, nil
+}
+
+func (p *eventService) GetForever(env *restrpc.Env) (ret *rpcutil.EventStream, err error) {
+
+	ch := make(chan rpcutil.Event)
+	go func() {
+		defer close(p.done)
+		for {
+			select {
+			case ch <- rpcutil.Event{Data: "tick"}:
+				time.Sleep(time.Millisecond)
+			case <-env.Ctx.Done():
+				return
+			}
+		}
+	}()
+	return &rpcutil.EventStream{Events: ch, Heartbeat: time.Millisecond}, nil
+}
+
+func TestServerSentEvents(t *testing.T) {
+
+	service := &eventService{done: make(chan bool)}
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	mux := router.Register(service)
+
+	cases := []struct {
+		lastEventID string
+		resp        string
+	}{
+		{"", "id: 1\nevent: progress\ndata: {\"percent\":10}\n\n" +
+			"id: 2\nevent: progress\ndata: {\"percent\":20}\n\n" +
+			"id: 3\nevent: progress\ndata: {\"percent\":30}\n\n" +
+			"retry: 1000\ndata: bye\ndata: see you\n\n"},
+		{"2", "id: 3\nevent: progress\ndata: {\"percent\":30}\n\n" +
+			"retry: 1000\ndata: bye\ndata: see you\n\n"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/events", nil)
+		if c.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", c.lastEventID)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream" || !w.Flushed || w.Body.String() != c.resp {
+			t.Fatal("unexpected resp:", c.lastEventID, w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	// an EventSource accepts text/event-stream, which no codec supports
+	negotiateMux := (&restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{Repl: rpcutil.NegotiateRepl}),
+		Mux:     restrpc.NewServeMux(),
+	}).Register(service)
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	negotiateMux.ServeHTTP(w, req)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != cases[0].resp {
+		t.Fatal("unexpected negotiated resp:", w.Code, w.Header(), w.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req = httptest.NewRequest("GET", "/forever", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	served := make(chan bool)
+	go func() {
+		mux.ServeHTTP(w, req)
+		close(served)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("event stream not ended after the request is canceled")
+	}
+	select {
+	case <-service.done:
+	case <-time.After(time.Second):
+		t.Fatal("event producer not ended after the request is canceled")
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "data: tick\n\n") {
+		t.Fatal("unexpected resp:", body)
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+type wsEnv struct {
+	restrpc.Env
+}
+
+func (p *wsEnv) OpenEnv(rcvr interface{}, w *http.ResponseWriter, req *http.Request) error {
+	if req.Header.Get("Authorization") != "token" {
+		return httputil.NewError(401, "bad token")
+	}
+	return p.Env.OpenEnv(rcvr, w, req)
+}
+
+type jobEvent struct {
+	Job string `json:"job"`
+	Seq int    `json:"seq"`
+}
+
+type wsService struct {
+	canceled chan bool
+}
+
+func (p *wsService) WsEcho(ctx context.Context, conn *websocket.Conn, env *wsEnv) error {
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			<-ctx.Done()
+			p.canceled <- true
+			return nil
+		}
+		conn.WriteMessage(typ, msg)
+	}
+}
+
+func (p *wsService) WsJobs_Events(in <-chan jobEvent, out chan<- jobEvent, env *restrpc.Env) {
+	for ev := range in {
+		out <- jobEvent{Job: env.Args[0], Seq: ev.Seq + 1}
+	}
+}
+
+func (p *wsService) WsOnce(in <-chan jobEvent, out chan<- jobEvent) {
+	out <- jobEvent{Seq: 1}
+	close(out)
+	for range in { // until the peer closes
+	}
+}
+
+func (p *wsService) WsFail(conn *websocket.Conn) error {
+	return httputil.NewError(400, "bad job")
+}
+
+func (p *wsService) WsCrash(conn *websocket.Conn) error {
+	return httputil.NewError(503, "!"+strings.Repeat("服务不可用", 10)) // 151 bytes
+}
+
+func TestWebSocket(t *testing.T) {
+
+	service := &wsService{canceled: make(chan bool, 1)}
+	router := restrpc.Router{Mux: restrpc.NewServeMux()}
+	svr := httptest.NewServer(router.Register(service))
+	defer svr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	_, err := websocket.Dial(ctx, url+"/echo", nil)
+	if code, _, msg := httputil.GetErrorInfo(err); code != 401 || msg != "bad token" {
+		t.Fatal("unauthorized Dial:", err)
+	}
+
+	conn, err := websocket.Dial(ctx, url+"/echo", http.Header{"Authorization": {"token"}})
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatal("echo:", string(msg), err)
+	}
+	conn.Close()
+	select {
+	case <-service.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("ctx not canceled after the connection is closed")
+	}
+
+	conn, err = websocket.Dial(ctx, url+"/jobs/job1/events", nil)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	for i := 0; i < 3; i++ {
+		var ev jobEvent
+		conn.WriteJSON(&jobEvent{Seq: i * 10})
+		if err = conn.ReadJSON(&ev); err != nil || ev.Job != "job1" || ev.Seq != i*10+1 {
+			t.Fatal("ReadJSON:", ev, err)
+		}
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("{bad json"))
+	_, _, err = conn.ReadMessage()
+	if e, ok := err.(*websocket.CloseError); !ok || e.Code != websocket.CloseInvalidPayload {
+		t.Fatal("unexpected close:", err)
+	}
+	conn.Close()
+
+	conn, err = websocket.Dial(ctx, url+"/once", nil)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	var ev jobEvent
+	if err = conn.ReadJSON(&ev); err != nil || ev.Seq != 1 {
+		t.Fatal("ReadJSON:", ev, err)
+	}
+	conn.NetConn().SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, msg, err := conn.ReadMessage(); err == nil { // nothing is sent after out is closed
+		t.Fatal("unexpected message:", string(msg))
+	}
+	conn.Close()
+
+	closes := []struct {
+		path string
+		code int
+		text string
+	}{
+		{"/fail", websocket.ClosePolicyViolation, "bad job"},
+		{"/crash", websocket.CloseInternalServerError, "!" + strings.Repeat("服务不可用", 8)}, // cut to 121 bytes
+	}
+	for i, c := range closes {
+		conn, err = websocket.Dial(ctx, url+c.path, nil)
+		if err != nil {
+			t.Fatal(i, "Dial failed:", err)
+		}
+		_, _, err = conn.ReadMessage()
+		if e, ok := err.(*websocket.CloseError); !ok || e.Code != c.code || e.Text != c.text {
+			t.Fatal(i, "unexpected close:", err)
+		}
+		conn.Close()
+	}
+}
@@ -1,8 +1,15 @@
 package restrpc
 
 import (
+	"bufio"
+	"context"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+
+	"github.com/qiniu/http/httputil"
 )
 
 // Pattern of POST /servers/<ServerId>/action => []string{"POST", "servers", "*", "action"}
@@ -11,7 +18,12 @@ type Pattern []string
 // Match matches pattern of a http request.
 func (p Pattern) Match(method string, cmds []string) (args []string, ok bool) {
 
-	if len(cmds)+1 != len(p) {
+	n := len(p)
+	if isCatchAll(p[n-1]) {
+		if len(cmds)+1 < n {
+			return
+		}
+	} else if len(cmds)+1 != n {
 		return
 	}
 
@@ -19,8 +31,12 @@ func (p Pattern) Match(method string, cmds []string) (args []string, ok bool) {
 		return
 	}
 
-	for i := 1; i < len(p); i++ {
-		if p[i] == "*" {
+	for i := 1; i < n; i++ {
+		if i == n-1 && isCatchAll(p[i]) {
+			args = append(args, strings.Join(cmds[i-1:], "/"))
+			break
+		}
+		if isWild(p[i]) {
 			args = append(args, cmds[i-1])
 			continue
 		}
@@ -32,7 +48,14 @@ func (p Pattern) Match(method string, cmds []string) (args []string, ok bool) {
 	return
 }
 
-// NewPattern creates a new pattern. eg. "POST /servers/*/action"
+// String returns the pattern in the form accepted by NewPattern.
+func (p Pattern) String() string {
+
+	return p[0] + " /" + strings.Join(p[1:], "/")
+}
+
+// NewPattern creates a new pattern. eg. "POST /servers/*/action" or
+// "GET /buckets/{bucket}/objects/{key:int}"
 func NewPattern(pattern string) Pattern {
 
 	parts := strings.Split(pattern, "/")
@@ -45,12 +68,132 @@ func NewPattern(pattern string) Pattern {
 type route struct {
 	pattern Pattern
 	handler http.Handler
+	params  []*pathParam // nil items for anonymous wildcards; nil if none is named
+}
+
+// node is a node of the routing trie. Each node represents a path segment;
+// static children are keyed by the lower-cased segment, wildcard segments
+// ("*" or "{name}") share one child, and so do catch-all segments ("**" or
+// "{name...}"), which match the rest of the path.
+type node struct {
+	static map[string]*node
+	wild   *node
+	tail   *node
+	routes map[string]*route // keyed by the upper-cased method
 }
 
-// ServeMux is an HTTP request multiplexer.
+func (n *node) insert(pattern Pattern, handler http.Handler) {
+
+	for i, seg := range pattern[1:] {
+		if isCatchAll(seg) {
+			if i != len(pattern)-2 {
+				panic("restrpc: catch-all segment must be the last one: " + pattern.String())
+			}
+			if n.tail == nil {
+				n.tail = new(node)
+			}
+			n = n.tail
+			continue
+		}
+		if isWild(seg) {
+			if n.wild == nil {
+				n.wild = new(node)
+			}
+			n = n.wild
+			continue
+		}
+		key := strings.ToLower(seg)
+		child, ok := n.static[key]
+		if !ok {
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			child = new(node)
+			n.static[key] = child
+		}
+		n = child
+	}
+
+	method := strings.ToUpper(pattern[0])
+	if r, ok := n.routes[method]; ok {
+		panic("restrpc: multiple registrations for " + pattern.String() + ", conflicting with " + r.pattern.String())
+	}
+	if n.routes == nil {
+		n.routes = make(map[string]*route)
+	}
+	r := &route{pattern: pattern, handler: handler}
+	if params, named := parsePathParams(pattern); named {
+		r.params = params
+	}
+	n.routes[method] = r
+}
+
+// accept reports whether args satisfy the constraints of the path
+// parameters of r.
+func (r *route) accept(args []string) bool {
+
+	for i, p := range r.params {
+		if p != nil && p.re != nil && !p.re.MatchString(args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// match finds the route of method and path segments parts. Static segments
+// take precedence over wildcard ones, which take precedence over catch-all
+// ones; if a branch doesn't match, the next one is tried. If fallThrough,
+// neither does a route whose path parameters don't satisfy its constraints.
+func (n *node) match(method string, parts []string, args []string, fallThrough bool) (*route, []string) {
+
+	if len(parts) == 0 {
+		if r, ok := n.routes[method]; ok && (!fallThrough || r.accept(args)) {
+			return r, args
+		}
+		return nil, nil
+	}
+
+	seg := parts[0]
+	if child, ok := n.static[strings.ToLower(seg)]; ok {
+		if r, args1 := child.match(method, parts[1:], args, fallThrough); r != nil {
+			return r, args1
+		}
+	}
+	if n.wild != nil {
+		if r, args1 := n.wild.match(method, parts[1:], append(args, seg), fallThrough); r != nil {
+			return r, args1
+		}
+	}
+	if n.tail != nil {
+		return n.tail.match(method, nil, append(args, strings.Join(parts, "/")), fallThrough)
+	}
+	return nil, nil
+}
+
+// splitPath splits the escaped path of a request into segments, and unescapes
+// each of them, so an escaped "/" (%2F) doesn't split a segment.
+func splitPath(u *url.URL) []string {
+
+	parts := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		if part1, err := url.PathUnescape(part); err == nil {
+			parts[i] = part1
+		}
+	}
+	return parts
+}
+
+// ServeMux is an HTTP request multiplexer. Routes are stored in a trie, so
+// the cost of dispatching a request is proportional to the length of its
+// path, rather than the number of routes.
 type ServeMux struct {
-	routes []*route
-	base   http.Handler
+	root node
+	base http.Handler
+
+	// FallThrough makes a route whose path parameters don't satisfy their
+	// constraints not match, so that the next candidate is tried (and 404 is
+	// replied if none matches). By default, such a request is replied 400.
+	FallThrough bool
 }
 
 // DefaultServeMux is the default ServeMux used by Serve.
@@ -70,10 +213,12 @@ func (h *ServeMux) SetDefault(handler http.Handler) {
 
 func (h *ServeMux) handle(pattern Pattern, handler http.Handler) {
 
-	h.routes = append(h.routes, &route{pattern, handler})
+	h.root.insert(pattern, handler)
 }
 
-// Handle registers the handler for the given pattern.
+// Handle registers the handler for the given pattern. It panics if a
+// handler already exists for a pattern of the same method and path shape,
+// which differs only in the names or constraints of path parameters.
 func (h *ServeMux) Handle(pattern string, handler http.Handler) {
 
 	h.handle(NewPattern(pattern), handler)
@@ -86,16 +231,53 @@ func (h *ServeMux) HandleFunc(pattern string, handler func(http.ResponseWriter,
 }
 
 // ServeHTTP dispatches the request to the handler whose pattern most closely matches the request URL.
+//
+// If the path matches some patterns but the method doesn't, ServeHTTP replies
+// 405 with an Allow header, or 204 with an Allow header for an OPTIONS
+// request. A HEAD request is served by the GET handler if there is no HEAD
+// handler, whose writes are passed to net/http, so that the header (e.g. the
+// sniffed Content-Type and the computed Content-Length) is the same as to a
+// GET request, and net/http drops the body. The context of a HEAD request is
+// canceled once the header is written, so that a streaming handler (e.g. of
+// server-sent events) returns.
 func (h *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	parts := strings.Split(r.URL.Path[1:], "/")
+	parts := splitPath(r.URL)
+	method := strings.ToUpper(r.Method)
 
-	for _, route := range h.routes {
-		if args, ok := route.pattern.Match(r.Method, parts); ok {
-			r.Header["*"] = args
-			route.handler.ServeHTTP(w, r)
-			return
+	route, args := h.root.match(method, parts, nil, h.FallThrough)
+	if method == "HEAD" {
+		if route == nil {
+			route, args = h.root.match("GET", parts, nil, h.FallThrough)
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+		w = &headResponseWriter{ResponseWriter: w, cancel: cancel}
+	}
+	if route != nil {
+		r.Header["*"] = args
+		r = httputil.WithRoute(r, route.pattern.String())
+		if route.params != nil {
+			params, err := bindPathParams(route.params, args)
+			if err != nil {
+				httputil.Error(w, err)
+				return
+			}
+			r = withPathParams(r, params)
 		}
+		route.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if allowed := make(map[string]bool); h.root.allowed(parts, nil, h.FallThrough, allowed) {
+		w.Header().Set("Allow", allowHeader(allowed))
+		if method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			httputil.ReplyWithCode(w, http.StatusMethodNotAllowed)
+		}
+		return
 	}
 
 	if h.base != nil {
@@ -104,3 +286,86 @@ func (h *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 	}
 }
+
+// allowed collects the methods of all routes matching path segments parts.
+func (n *node) allowed(parts []string, args []string, fallThrough bool, methods map[string]bool) (found bool) {
+
+	if len(parts) == 0 {
+		for method, r := range n.routes {
+			if !fallThrough || r.accept(args) {
+				methods[method] = true
+				found = true
+			}
+		}
+		return
+	}
+
+	seg := parts[0]
+	if child, ok := n.static[strings.ToLower(seg)]; ok {
+		found = child.allowed(parts[1:], args, fallThrough, methods)
+	}
+	if n.wild != nil && n.wild.allowed(parts[1:], append(args, seg), fallThrough, methods) {
+		found = true
+	}
+	if n.tail != nil && n.tail.allowed(nil, append(args, strings.Join(parts, "/")), fallThrough, methods) {
+		found = true
+	}
+	return
+}
+
+func allowHeader(methods map[string]bool) string {
+
+	if methods["GET"] {
+		methods["HEAD"] = true
+	}
+	methods["OPTIONS"] = true
+	allow := make([]string, 0, len(methods))
+	for method := range methods {
+		allow = append(allow, method)
+	}
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+// headResponseWriter cancels a HEAD request once the header is written.
+type headResponseWriter struct {
+	http.ResponseWriter
+	cancel context.CancelFunc
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+
+	w.ResponseWriter.WriteHeader(code)
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.cancel()
+	}
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+
+	defer w.cancel()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+
+	return w.ResponseWriter
+}
+
+func (w *headResponseWriter) Flush() {
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	w.cancel()
+}
+
+// Hijack takes over the connection, see http.Hijacker.
+func (w *headResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
@@ -2,8 +2,13 @@ package restrpc
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/qiniu/http/httputil"
 )
 
 type testcase struct {
@@ -61,6 +66,18 @@ var matchCases = []testcase{
 		pattern: "POST /bar/*/foo/*",
 		args:    []string{"bar-param", "foo-param"},
 	},
+	{
+		method:  "GET",
+		path:    "/bar/bar-param/foo/a/b",
+		pattern: "GET /bar/*/foo/**",
+		args:    []string{"bar-param", "a/b"},
+	},
+	{
+		method:  "GET",
+		path:    "/bar/bar-param/foo/12",
+		pattern: "GET /bar/{bar}/foo/{foo:int}",
+		args:    []string{"bar-param", "12"},
+	},
 }
 
 func TestMatch(t *testing.T) {
@@ -75,3 +92,296 @@ func TestMatch(t *testing.T) {
 		}
 	}
 }
+
+// ---------------------------------------------------------------------------
+
+type routeTestCase struct {
+	method string
+	path   string
+	route  string
+	args   []string
+}
+
+func TestServeMux(t *testing.T) {
+
+	patterns := []string{
+		"GET /a/*",
+		"GET /a/b",
+		"GET /a/b/c",
+		"GET /a/*/d",
+		"POST /a/*/d",
+		"GET /*/*/d",
+		"GET /",
+		"GET /files/**",
+		"GET /files/{dir}/meta",
+	}
+	mux := NewServeMux()
+	for _, pattern := range patterns {
+		pattern := pattern
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Route", pattern)
+		})
+	}
+
+	cases := []routeTestCase{
+		{"GET", "/a/b", "GET /a/b", nil},
+		{"GET", "/A/B", "GET /a/b", nil},
+		{"GET", "/a/x", "GET /a/*", []string{"x"}},
+		{"GET", "/a/b/c", "GET /a/b/c", nil},
+		{"GET", "/a/b/d", "GET /a/*/d", []string{"b"}},
+		{"post", "/a/b/d", "POST /a/*/d", []string{"b"}},
+		{"GET", "/x/y/d", "GET /*/*/d", []string{"x", "y"}},
+		{"GET", "/", "GET /", nil},
+		{"GET", "/a/b/c/d", "", nil},
+		{"GET", "/files/a/b%2Fc.jpg", "GET /files/**", []string{"a/b/c.jpg"}},
+		{"GET", "/files/a%2Fb/meta", "GET /files/{dir}/meta", []string{"a/b"}},
+		{"GET", "/files/a/meta/x", "GET /files/**", []string{"a/meta/x"}},
+		{"GET", "/files", "", nil},
+		{"DELETE", "/a/b", "", nil},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, "http://foo.com"+c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if route := w.Header().Get("X-Route"); route != c.route {
+			t.Fatal("unexpected route:", c.method, c.path, route)
+		}
+		if c.route != "" && strings.Join(req.Header["*"], "/") != strings.Join(c.args, "/") {
+			t.Fatal("unexpected args:", c.method, c.path, req.Header["*"])
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// linearMux dispatches requests by scanning all patterns, as ServeMux did
+// before it was backed by a trie.
+type linearMux struct {
+	routes []*route
+}
+
+func (h *linearMux) Handle(pattern string, handler http.Handler) {
+
+	h.routes = append(h.routes, &route{pattern: NewPattern(pattern), handler: handler})
+}
+
+func (h *linearMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path[1:], "/")
+	for _, route := range h.routes {
+		if args, ok := route.pattern.Match(r.Method, parts); ok {
+			r.Header["*"] = args
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+type benchMux interface {
+	Handle(pattern string, handler http.Handler)
+	ServeHTTP(w http.ResponseWriter, r *http.Request)
+}
+
+var benchMethods = []string{"GET", "POST", "PUT", "DELETE"}
+
+func benchmarkMux(b *testing.B, mux benchMux, nres int) {
+
+	nop := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	for i := 0; i < nres; i++ {
+		res := "res" + strconv.Itoa(i)
+		for _, method := range benchMethods {
+			mux.Handle(method+" /v1/"+res, nop)
+			mux.Handle(method+" /v1/"+res+"/*", nop)
+			mux.Handle(method+" /v1/"+res+"/*/sub/*", nop)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", "http://foo.com/v1/res"+strconv.Itoa(nres-1)+"/id/sub/id2", nil)
+	w := httptest.NewRecorder()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkLinearMux10(b *testing.B)  { benchmarkMux(b, new(linearMux), 10) }
+func BenchmarkLinearMux100(b *testing.B) { benchmarkMux(b, new(linearMux), 100) }
+func BenchmarkLinearMux500(b *testing.B) { benchmarkMux(b, new(linearMux), 500) }
+func BenchmarkServeMux10(b *testing.B)   { benchmarkMux(b, NewServeMux(), 10) }
+func BenchmarkServeMux100(b *testing.B)  { benchmarkMux(b, NewServeMux(), 100) }
+func BenchmarkServeMux500(b *testing.B)  { benchmarkMux(b, NewServeMux(), 500) }
+
+// ---------------------------------------------------------------------------
+
+func TestMethodNotAllowed(t *testing.T) {
+
+	mux := NewServeMux()
+	mux.HandleFunc("GET /a/*", func(w http.ResponseWriter, req *http.Request) {
+		httputil.ReplyWith(w, 200, "text/plain", []byte("GET "+req.Method))
+	})
+	mux.HandleFunc("PUT /a/b", func(w http.ResponseWriter, req *http.Request) {})
+	mux.HandleFunc("OPTIONS /c", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	cases := []struct {
+		method, path string
+		code         int
+		allow, body  string
+	}{
+		{"POST", "/a/b", 405, "GET, HEAD, OPTIONS, PUT", `{"error":"Method Not Allowed"}`},
+		{"DELETE", "/a/x", 405, "GET, HEAD, OPTIONS", `{"error":"Method Not Allowed"}`},
+		{"OPTIONS", "/a/b", 204, "GET, HEAD, OPTIONS, PUT", ""},
+		{"OPTIONS", "/c", 200, "", ""},
+		{"HEAD", "/a/x", 200, "", "GET HEAD"}, // net/http drops the body
+		{"GET", "/a/x", 200, "", "GET GET"},
+		{"POST", "/x", 404, "", "404 page not found\n"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, "http://foo.com"+c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Allow") != c.allow || w.Body.String() != c.body {
+			t.Fatal("unexpected resp:", c.method, c.path, w.Code, w.Header().Get("Allow"), w.Body.String())
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+
+	mux := NewServeMux()
+	mux.HandleFunc("GET /users/{id:int}", func(w http.ResponseWriter, req *http.Request) {
+		httputil.ReplyWith(w, 200, "text/plain", []byte("id "+PathParams(req).Get("id")))
+	})
+	mux.HandleFunc("GET /users/{name}/profile", func(w http.ResponseWriter, req *http.Request) {
+		httputil.ReplyWith(w, 200, "text/plain", []byte("profile "+PathParams(req).Get("name")))
+	})
+	mux.HandleFunc("GET /files/{key:[a-z]+}", func(w http.ResponseWriter, req *http.Request) {
+		httputil.ReplyWith(w, 200, "text/plain", []byte("file "+PathParams(req).Get("key")))
+	})
+	mux.HandleFunc("GET /files/{path...}", func(w http.ResponseWriter, req *http.Request) {
+		httputil.ReplyWith(w, 200, "text/plain", []byte("path "+PathParams(req).Get("path")))
+	})
+
+	cases := []struct {
+		fallThrough  bool
+		method, path string
+		code         int
+		body         string
+	}{
+		{false, "GET", "/users/12", 200, "id 12"},
+		{false, "GET", "/users/abc", 400, `{"error":"invalid path parameter ` + "`id`" + `: abc"}`},
+		{false, "POST", "/users/abc", 405, `{"error":"Method Not Allowed"}`},
+		{false, "GET", "/files/a1", 400, `{"error":"invalid path parameter ` + "`key`" + `: a1"}`},
+		{true, "GET", "/users/12", 200, "id 12"},
+		{true, "GET", "/users/abc", 404, "404 page not found\n"},
+		{true, "POST", "/users/abc", 404, "404 page not found\n"},
+		{true, "POST", "/users/12", 405, `{"error":"Method Not Allowed"}`},
+		{true, "GET", "/users/abc/profile", 200, "profile abc"},
+		{true, "GET", "/files/abc", 200, "file abc"},
+		{true, "GET", "/files/a1", 200, "path a1"},
+		{true, "GET", "/files/a/b", 200, "path a/b"},
+	}
+	for _, c := range cases {
+		mux.FallThrough = c.fallThrough
+		req, _ := http.NewRequest(c.method, "http://foo.com"+c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != c.code || w.Body.String() != c.body {
+			t.Fatal("unexpected resp:", c.fallThrough, c.method, c.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDuplicateRoutes(t *testing.T) {
+
+	cases := [][2]string{
+		{"GET /users/{id:int}", "GET /users/{name}"},
+		{"GET /users/*", "get /Users/{id}"},
+		{"GET /files/**", "GET /files/{path...}"},
+	}
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic on registering", c[1], "after", c[0])
+				}
+			}()
+			mux := NewServeMux()
+			mux.HandleFunc(c[0], func(w http.ResponseWriter, req *http.Request) {})
+			mux.HandleFunc(c[1], func(w http.ResponseWriter, req *http.Request) {})
+		}()
+	}
+
+	mux := NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	mux.HandleFunc("PUT /users/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	mux.HandleFunc("GET /users/{id}/profile", func(w http.ResponseWriter, req *http.Request) {})
+}
+
+func TestHeadHeader(t *testing.T) {
+
+	mux := NewServeMux()
+	mux.HandleFunc("GET /page", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("<html><body>hello</body></html>"))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	var headers [2]http.Header
+	for i, method := range []string{"GET", "HEAD"} {
+		req, _ := http.NewRequest(method, svr.URL+"/page", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != 200 {
+			t.Fatal(method, resp, err)
+		}
+		resp.Body.Close()
+		headers[i] = resp.Header
+	}
+	for _, k := range []string{"Content-Type", "Content-Length"} {
+		if v := headers[0].Get(k); v == "" || headers[1].Get(k) != v {
+			t.Fatal("unexpected header:", k, headers[0].Get(k), headers[1].Get(k))
+		}
+	}
+}
+
+func TestHeadStreaming(t *testing.T) {
+
+	hijackable := make(chan bool, 1)
+	done := make(chan struct{})
+	mux := NewServeMux()
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, req *http.Request) {
+		_, ok := w.(http.Hijacker)
+		hijackable <- ok
+		w.Header().Set("Content-Type", "text/event-stream")
+		defer close(done)
+		for {
+			w.Write([]byte("data: ping\n\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	resp, err := http.Head(svr.URL + "/events")
+	if err != nil || resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("HEAD:", resp, err)
+	}
+	resp.Body.Close()
+	if !<-hijackable {
+		t.Fatal("the writer of a HEAD request isn't a http.Hijacker")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the streaming handler of a HEAD request doesn't return")
+	}
+}
+
+// ---------------------------------------------------------------------------
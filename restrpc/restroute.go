@@ -1,11 +1,13 @@
 package restrpc
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"reflect"
 	"strings"
 
+	"github.com/qiniu/http/formutil"
 	"github.com/qiniu/http/hfac"
 )
 
@@ -23,6 +25,7 @@ type Router struct {
 	Factory       hfac.HandlerFactory
 	PatternPrefix string
 	Separator     string
+	CatchAll      bool // method names ending with three separators end with a catch-all segment "**"
 	Mux           Mux
 	Default       http.Handler
 }
@@ -35,6 +38,13 @@ func (r *Router) ListenAndServe(addr string, rcvr interface{}) error {
 	return http.ListenAndServe(addr, r.Register(rcvr))
 }
 
+// Route describes a route of a receiver method.
+type Route struct {
+	Pattern Pattern
+	Method  reflect.Method
+	Handler http.Handler
+}
+
 // Register registers route to the Mux instance of Router.
 func (r *Router) Register(rcvr interface{}, routes ...[][2]string) Mux {
 
@@ -46,6 +56,17 @@ func (r *Router) Register(rcvr interface{}, routes ...[][2]string) Mux {
 	}
 	mux := r.Mux
 
+	for _, route := range r.Routes(rcvr, routes...) {
+		mux.Handle(route.Pattern.String(), route.Handler)
+		log.Println("Install", route.Pattern, "=>", route.Method.Name)
+	}
+	return mux
+}
+
+// Routes returns the routes Register installs for a receiver, without
+// installing them.
+func (r *Router) Routes(rcvr interface{}, routes ...[][2]string) (ret []Route) {
+
 	factory := r.Factory
 	if factory == nil {
 		factory = Factory
@@ -68,16 +89,13 @@ func (r *Router) Register(rcvr interface{}, routes ...[][2]string) Mux {
 			method := typ.Method(m)
 			prefix, handler, err := factory.Create(rcvr1, method)
 			if err != nil {
+				if _, ok := err.(*formutil.TagError); ok { // a handler with malformed validate tags
+					log.Fatalln("Install", method.Name, "failed:", err)
+				}
 				continue
 			}
-			pattern := []string{prefix}
-			if patternPrefix != "" {
-				pattern = append(pattern, patternPrefix)
-			}
-			pattern = append(pattern, patternOf(method.Name[len(prefix):], sep)...)
-
-			mux.Handle(strings.Join(pattern, "/"), handler)
-			log.Println("Install", pattern, "=>", method.Name)
+			pattern := methodPattern(prefix, method.Name[len(prefix):], patternPrefix, sep, r.CatchAll)
+			ret = append(ret, Route{pattern, method, handler})
 		}
 	} else {
 		for _, item := range routes[0] {
@@ -96,20 +114,92 @@ func (r *Router) Register(rcvr interface{}, routes ...[][2]string) Mux {
 			if err != nil {
 				log.Fatalln("Install", pattern, "=>", item[1], "failed:", err)
 			}
-			mux.Handle(pattern, handler)
-			log.Println("Install", pattern, "=>", item[1])
+			ret = append(ret, Route{NewPattern(pattern), method, handler})
 		}
 	}
-	return mux
+	if err := checkConflicts(ret); err != nil {
+		log.Fatalln("Install failed:", err)
+	}
+	return
+}
+
+// checkConflicts returns an error if two routes have the same method and
+// path shape (see ServeMux.Handle), eg. GetEvents and WsEvents, which are
+// both GET /events.
+func checkConflicts(routes []Route) error {
+
+	seen := make(map[string]string, len(routes))
+	for _, route := range routes {
+		key := routeKeyOf(route.Pattern)
+		if name, ok := seen[key]; ok {
+			return errors.New("route " + route.Pattern.String() + " of " + route.Method.Name + " conflicts with " + name)
+		}
+		seen[key] = route.Method.Name
+	}
+	return nil
+}
+
+func routeKeyOf(pattern Pattern) string {
+
+	key := strings.ToUpper(pattern[0])
+	for _, seg := range pattern[1:] {
+		switch {
+		case isCatchAll(seg):
+			seg = "**"
+		case isWild(seg):
+			seg = "*"
+		default:
+			seg = strings.ToLower(seg)
+		}
+		key += "/" + seg
+	}
+	return key
+}
+
+// MethodPattern returns the pattern Router.Register derives from a method
+// name, eg. MethodPattern("PostFoo_Bar", "/v1", "") returns
+// Pattern{"Post", "v1", "Foo", "*", "Bar"}.
+func MethodPattern(name string, patternPrefix, sep string) (Pattern, bool) {
+
+	return MethodPatternEx(name, patternPrefix, sep, false)
+}
+
+// MethodPatternEx is MethodPattern of a Router with CatchAll, eg.
+// MethodPatternEx("GetFoo_Bar___", "", "", true) returns
+// Pattern{"Get", "Foo", "*", "Bar", "**"}.
+func MethodPatternEx(name string, patternPrefix, sep string, catchAll bool) (Pattern, bool) {
+
+	prefix, ok := hfac.PrefixOf(name)
+	if !ok {
+		return nil, false
+	}
+	if sep == "" {
+		sep = "_"
+	}
+	return methodPattern(prefix, name[len(prefix):], strings.TrimPrefix(patternPrefix, "/"), sep, catchAll), true
+}
+
+func methodPattern(prefix, name string, patternPrefix, sep string, catchAll bool) Pattern {
+
+	if prefix == "Ws" { // WebSocket handshakes are GET requests, see hfac.NewWsHandler
+		prefix = "Get"
+	}
+	pattern := Pattern{prefix}
+	if patternPrefix != "" {
+		pattern = append(pattern, patternPrefix)
+	}
+	if tail := sep + sep + sep; catchAll && strings.HasSuffix(name, tail) {
+		pattern = append(pattern, patternOf(name[:len(name)-len(tail)], sep)...)
+		return append(pattern, "**")
+	}
+	return append(pattern, patternOf(name, sep)...)
 }
 
-//
 // AppleBanana => ["Apple", "Banana"]
 // Apple_Banana => ["Apple", "*", "Banana"]
 // AppleBanana_ => ["Apple", "Banana", "*"]
 // Apple_Banana_ => ["Apple", "*", "Banana", "*"]
 // ...
-//
 func patternOf(method string, sep string) (pattern []string) {
 
 	for method != "" {
@@ -2,6 +2,7 @@ package restrpc
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -23,6 +24,7 @@ func TestPatternOf(t *testing.T) {
 		{"App_le__Banana", Pattern{"App_le", "*", "Banana"}, "__"},
 		{"Apple_Banana__", Pattern{"Apple_", "Banana", "*"}, "__"},
 		{"Apple__Banana__", Pattern{"Apple", "*", "Banana", "*"}, "__"},
+		{"Apple_Banana___", Pattern{"Apple", "*", "Banana", "*", "*", "*"}, "_"},
 	}
 	for _, c := range cases {
 		pattern := patternOf(c.Method, c.Sep)
@@ -32,3 +34,57 @@ func TestPatternOf(t *testing.T) {
 		}
 	}
 }
+
+func TestMethodPatternEx(t *testing.T) {
+
+	cases := []struct {
+		Name     string
+		Pattern  Pattern
+		Sep      string
+		CatchAll bool
+	}{
+		{"GetFoo_Bar___", Pattern{"Get", "Foo", "*", "Bar", "*", "*", "*"}, "", false}, // routes of existing methods don't change
+		{"GetFoo_Bar___", Pattern{"Get", "Foo", "*", "Bar", "**"}, "", true},
+		{"GetFoo______", Pattern{"Get", "Foo", "**"}, "__", true},
+		{"GetFoo_Bar_", Pattern{"Get", "Foo", "*", "Bar", "*"}, "", true},
+	}
+	for _, c := range cases {
+		pattern, ok := MethodPatternEx(c.Name, "", c.Sep, c.CatchAll)
+		if !ok || strings.Join(pattern, "/") != strings.Join(c.Pattern, "/") {
+			t.Fatal("MethodPatternEx failed:", c, pattern)
+		}
+	}
+}
+
+type conflictService struct{}
+
+func (p *conflictService) GetEvents()      {}
+func (p *conflictService) WsEvents()       {}
+func (p *conflictService) GetJobs_Events() {}
+func (p *conflictService) WsJobs_Logs()    {}
+func (p *conflictService) PostEvents()     {}
+func (p *conflictService) method(name string) reflect.Method {
+	m, _ := reflect.TypeOf(p).MethodByName(name)
+	return m
+}
+
+func TestCheckConflicts(t *testing.T) {
+
+	p := new(conflictService)
+	routeOf := func(name string) Route {
+		pattern, _ := MethodPattern(name, "", "")
+		return Route{Pattern: pattern, Method: p.method(name)}
+	}
+	ok := []Route{routeOf("GetEvents"), routeOf("PostEvents"), routeOf("GetJobs_Events"), routeOf("WsJobs_Logs")}
+	if err := checkConflicts(ok); err != nil {
+		t.Fatal("checkConflicts:", err)
+	}
+	err := checkConflicts(append(ok, routeOf("WsEvents")))
+	if err == nil || err.Error() != "route Get /Events of WsEvents conflicts with GetEvents" {
+		t.Fatal("checkConflicts:", err)
+	}
+	manual := Route{Pattern: NewPattern("GET /jobs/{id}/events"), Method: p.method("PostEvents")}
+	if err = checkConflicts(append(ok, manual)); err == nil {
+		t.Fatal("no conflict of", manual.Pattern)
+	}
+}
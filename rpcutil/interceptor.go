@@ -0,0 +1,49 @@
+package rpcutil
+
+import (
+	"context"
+	"net/http"
+)
+
+// ---------------------------------------------------------------------------
+
+// Invocation represents a call of a handler method.
+type Invocation struct {
+	Method string          // name of the method, eg. "PostFoo_Bar"
+	Ctx    context.Context // Context passed to the method
+	W      http.ResponseWriter
+	Req    *http.Request
+	Args   interface{} // decoded request, eg. *Args; nil if the method has no req argument
+	Env    interface{} // Env or *ENV; nil if the method has no env argument
+}
+
+// Invoker invokes a handler method. ret is nil if the method returns no value.
+type Invoker func(inv *Invocation) (ret interface{}, err error)
+
+// Interceptor runs code around a handler method call. It calls next to
+// continue the chain, or returns without calling it to short-circuit. It may
+// change inv (eg. replace inv.Ctx) before calling next, and rewrite ret or err
+// before returning them.
+//
+// The returned err is replied by Replier.Error; otherwise ret (if not nil) is
+// replied by Replier.Reply (or as is, see Response).
+type Interceptor func(inv *Invocation, next Invoker) (ret interface{}, err error)
+
+// Chain returns an Invoker which runs interceptors in order around last.
+func Chain(interceptors []Interceptor, last Invoker) Invoker {
+
+	invoke := last
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		invoke = chainOne(interceptors[i], invoke)
+	}
+	return invoke
+}
+
+func chainOne(interceptor Interceptor, next Invoker) Invoker {
+
+	return func(inv *Invocation) (ret interface{}, err error) {
+		return interceptor(inv, next)
+	}
+}
+
+// ---------------------------------------------------------------------------
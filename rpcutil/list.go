@@ -0,0 +1,145 @@
+package rpcutil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/qiniu/http/httputil"
+)
+
+/* ---------------------------------------------------------------------------
+
+方法返回 ListIterator(或者元素不是 Event 的 <-chan T)时，handler 逐个编码列表中的元素，
+不必把整个列表放在内存里：
+
+	func (p *Service) GetBuckets_Objects(env *restrpc.Env) (ret rpcutil.ListIterator, err error) {
+		it, err := p.store.List(env.Ctx, env.Args[0])
+		if err != nil {
+			return
+		}
+		return rpcutil.ListFunc(func() (interface{}, error) {
+			obj, err := it.Next() // 最后一个元素之后返回 io.EOF
+			return obj, err
+		}), nil
+	}
+
+按请求的 Accept 选择格式：application/x-ndjson 时每行一个 JSON 值，否则为一个 JSON 数组。状态码
+与其他返回值一样，缺省为 200，可以由 HandlerCreator.StatusCodes 或 ret 的 StatusCode() 指定。
+
+响应头写出后如果出错，错误放在 trailer X-Stream-Error 中(见 httputil.SetStreamError)，JSON
+数组不会以 ] 结束，以免客户端把不完整的列表当作完整的列表。restclient.ListReader 可以解析
+这两种格式以及 trailer 中的错误。
+
+迭代器如果实现了 io.Closer，回复结束后会被 Close。
+
+// -------------------------------------------------------------------------*/
+
+// ListIterator iterates the items of a list returned by a method. Next
+// returns io.EOF after the last item.
+type ListIterator interface {
+	Next() (item interface{}, err error)
+}
+
+// ListFunc is a ListIterator of a func.
+type ListFunc func() (item interface{}, err error)
+
+// Next returns the next item, or io.EOF after the last item.
+func (f ListFunc) Next() (item interface{}, err error) {
+
+	return f()
+}
+
+var typeOfEvent = reflect.TypeOf(Event{})
+
+// listOf returns the ListIterator of ret if it is replied as a list.
+func listOf(ctx context.Context, ret interface{}) (ListIterator, bool) {
+
+	if it, ok := ret.(ListIterator); ok {
+		return it, true
+	}
+	v := reflect.ValueOf(ret)
+	if v.Kind() != reflect.Chan || v.Type().ChanDir()&reflect.RecvDir == 0 || v.Type().Elem() == typeOfEvent {
+		return nil, false
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: v},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	return ListFunc(func() (interface{}, error) {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return nil, ctx.Err()
+		}
+		if !ok {
+			return nil, io.EOF
+		}
+		return item.Interface(), nil
+	}), true
+}
+
+var (
+	listBegin = []byte{'['}
+	listSep   = []byte{',', '\n'}
+	listEnd   = []byte{']', '\n'}
+	lineEnd   = []byte{'\n'}
+)
+
+func replyList(w http.ResponseWriter, req *http.Request, code int, it ListIterator) {
+
+	if c, ok := it.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	ndjson := httputil.Preferred(req, "application/json", httputil.NDJSONMediaType) == httputil.NDJSONMediaType
+	h := w.Header()
+	if ndjson {
+		h.Set("Content-Type", httputil.NDJSONMediaType)
+	} else {
+		h.Set("Content-Type", "application/json")
+	}
+	h.Set("Trailer", httputil.StreamErrorTrailer)
+	h.Del("Content-Length")
+	w.WriteHeader(code)
+	if req.Method == "HEAD" || !httputil.BodyAllowed(code) {
+		return
+	}
+
+	ctx := req.Context()
+	if !ndjson {
+		w.Write(listBegin)
+	}
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return // the client is gone
+		}
+		item, err := it.Next()
+		if err == nil {
+			var msg []byte
+			if msg, err = json.Marshal(item); err == nil {
+				if !ndjson && i > 0 {
+					w.Write(listSep)
+				}
+				if _, err = w.Write(msg); err != nil {
+					return
+				}
+				if ndjson {
+					w.Write(lineEnd)
+				}
+				continue
+			}
+		}
+		if err == io.EOF {
+			if !ndjson {
+				w.Write(listEnd)
+			}
+		} else {
+			httputil.SetStreamError(w, httputil.Localize(err, req))
+		}
+		return
+	}
+}
+
+// ---------------------------------------------------------------------------
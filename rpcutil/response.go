@@ -0,0 +1,313 @@
+package rpcutil
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+
+	"github.com/qiniu/http/httputil"
+)
+
+/* ---------------------------------------------------------------------------
+
+方法的 ret 默认由 Replier.Reply 编码(如 JSON)后回复。以下类型的 ret 会被直接回复：
+
+- Blob：一段指定 Content-Type 的数据，如图片缩略图。
+- Stream、io.Reader(含 io.ReadCloser)：流式回复，如下载文件。能得到大小时(Stream.Size >= 0，
+  或者 Reader 实现了 Len() int、同时实现了 Size() int64 与 io.Seeker，或者是 *os.File，大小均为
+  未读的部分)带 Content-Length，否则使用 chunked 编码。Content-Type 缺省为 application/octet-stream。回复后如果实现了 io.Closer 则调用
+  Close；方法返回错误时 ret 如果实现了 io.Closer 也会被 Close。
+- <-chan Event、EventStream：以 Server-Sent Events 回复，见 EventStream。
+- ListIterator、<-chan T：流式回复列表(NDJSON 或 JSON 数组)，见 ListIterator。
+- httputil.Content：按 httputil.ServeContent 回复，支持 Range 与条件请求，状态码由其决定。
+- Response：指定状态码、响应头与 body。body 可以是 nil、[]byte、Blob、Stream、
+  httputil.Content、io.Reader，或者其他由 Replier.Reply 编码的值。
+
+	func (p *Service) GetFiles_(env *restrpc.Env) (ret io.ReadCloser, err error) {
+		return os.Open(filepath.Join(p.root, env.Args[0]))
+	}
+
+	func (p *Service) PostThumbnails(args *thumbArgs) (ret rpcutil.Blob, err error) {
+		...
+		return rpcutil.Blob{ContentType: "image/png", Data: b.Bytes()}, nil
+	}
+
+	func (p *Service) PostJobs(args *jobArgs) (ret *rpcutil.Response, err error) {
+		...
+		return &rpcutil.Response{
+			Code: 202, Header: http.Header{"Location": {"/v1/jobs/" + id}}, Body: job,
+		}, nil
+	}
+
+流的 Content-Length 确定后如果读取出错，连接会被中断，以免客户端把截断的 body 当作完整的响应。
+
+成功时的状态码缺省为 200，可以通过 HandlerCreator.StatusCodes 按方法名或方法名前缀修改：
+
+	router := &restrpc.Router{
+		Factory: restrpc.NewFactory(rpcutil.HandlerCreator{
+			StatusCodes: map[string]int{"Post": 201, "Delete": 204, "PostJobs_Cancel": 200},
+		}),
+	}
+
+ret 也可以实现 StatusCode() int(见 httputil.StatusCoder)与 ReplyHeader(h http.Header) 来指定
+状态码与响应头，例如 201 与 Location。1xx、204 与 304 的响应没有 body。
+
+// -------------------------------------------------------------------------*/
+
+// Blob is a return value replied as is, with its content type.
+type Blob struct {
+	ContentType string // application/octet-stream if empty
+	Data        []byte
+}
+
+// Stream is a return value whose body is streamed. Body is closed after
+// replied if it implements io.Closer.
+type Stream struct {
+	ContentType string // application/octet-stream if empty
+	Size        int64  // size of Body; -1 if unknown (replied in chunked encoding)
+	Body        io.Reader
+}
+
+// Response is a return value carrying the status code and headers of the
+// reply. Body is nil (no data), a []byte, a Blob, a Stream, an
+// httputil.Content, an io.Reader, or a value replied by Replier.Reply.
+type Response struct {
+	Code   int // the default status code of the method if 0, see HandlerCreator.StatusCodes
+	Header http.Header
+	Body   interface{}
+}
+
+// ReplyHeaderer is implemented by return values setting headers of the reply,
+// eg. Location or ETag.
+type ReplyHeaderer interface {
+	ReplyHeader(h http.Header)
+}
+
+const octetStream = "application/octet-stream"
+
+// reply replies ret of a successful call.
+func (h *handler) reply(w http.ResponseWriter, req *http.Request, repl *Replier, ret interface{}) {
+
+	code := h.code
+	if ret != nil && !isNilPtr(ret) {
+		if v, ok := ret.(httputil.StatusCoder); ok {
+			if c := v.StatusCode(); c != 0 {
+				code = c
+			}
+		}
+		if v, ok := ret.(ReplyHeaderer); ok {
+			v.ReplyHeader(w.Header())
+		}
+	}
+
+	if replyRet(w, req, repl, code, ret) {
+		return
+	}
+	if ret != nil || h.hasRet > 0 {
+		if !httputil.BodyAllowed(code) {
+			w.WriteHeader(code)
+			return
+		}
+		repl.Reply(w, code, ret)
+	} else if h.hasRet == 0 {
+		repl.ReplyWithCode(w, code)
+	}
+}
+
+func isNilPtr(v interface{}) bool {
+
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// closeRet closes ret (or the body of ret) if it is an io.Closer. It is
+// called if the method returns an error with ret.
+func closeRet(ret interface{}) {
+
+	switch v := ret.(type) {
+	case *Response:
+		if v != nil {
+			closeRet(v.Body)
+		}
+	case Response:
+		closeRet(v.Body)
+	case *Stream:
+		if v != nil {
+			closeRet(v.Body)
+		}
+	case Stream:
+		closeRet(v.Body)
+	case httputil.Content:
+		v.Close()
+	case io.Closer: // including *httputil.Content
+		v.Close()
+	}
+}
+
+// replyRet replies ret if it is one of the return values replied as is, see
+// Blob, Stream and Response. It returns false if ret is not.
+func replyRet(w http.ResponseWriter, req *http.Request, repl *Replier, code int, ret interface{}) bool {
+
+	switch v := ret.(type) {
+	case *Response:
+		if v == nil {
+			return false
+		}
+		replyResponse(w, req, repl, code, v)
+	case Response:
+		replyResponse(w, req, repl, code, &v)
+	case ListIterator:
+		replyList(w, req, code, v)
+	case *Blob, Blob, *Stream, Stream, *httputil.Content, httputil.Content, io.Reader:
+		replyBody(w, req, repl, code, ret)
+	case <-chan Event:
+		serveEvents(w, req, &EventStream{Events: v})
+	case chan Event:
+		serveEvents(w, req, &EventStream{Events: v})
+	case *EventStream:
+		if v == nil {
+			return false
+		}
+		serveEvents(w, req, v)
+	case EventStream:
+		serveEvents(w, req, &v)
+	default:
+		it, ok := listOf(req.Context(), ret)
+		if !ok {
+			return false
+		}
+		replyList(w, req, code, it)
+	}
+	return true
+}
+
+func replyResponse(w http.ResponseWriter, req *http.Request, repl *Replier, code int, resp *Response) {
+
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = v
+	}
+	if resp.Code != 0 {
+		code = resp.Code
+	}
+	replyBody(w, req, repl, code, resp.Body)
+}
+
+func replyBody(w http.ResponseWriter, req *http.Request, repl *Replier, code int, body interface{}) {
+
+	if !httputil.BodyAllowed(code) {
+		closeRet(body)
+		w.WriteHeader(code)
+		return
+	}
+
+	switch v := body.(type) {
+	case nil:
+		repl.ReplyWithCode(w, code)
+	case []byte:
+		httputil.ReplyWith(w, code, contentTypeOf(w, ""), v)
+	case *Blob:
+		if v == nil {
+			repl.ReplyWithCode(w, code)
+			return
+		}
+		httputil.ReplyWith(w, code, contentTypeOf(w, v.ContentType), v.Data)
+	case Blob:
+		httputil.ReplyWith(w, code, contentTypeOf(w, v.ContentType), v.Data)
+	case *Stream:
+		if v == nil {
+			repl.ReplyWithCode(w, code)
+			return
+		}
+		replyStream(w, req, code, contentTypeOf(w, v.ContentType), v.Body, v.Size)
+	case Stream:
+		replyStream(w, req, code, contentTypeOf(w, v.ContentType), v.Body, v.Size)
+	case *httputil.Content:
+		if v == nil {
+			repl.ReplyWithCode(w, code)
+			return
+		}
+		httputil.ServeContent(w, req, v)
+	case httputil.Content:
+		httputil.ServeContent(w, req, &v)
+	case io.Reader:
+		replyStream(w, req, code, contentTypeOf(w, ""), v, sizeOf(v))
+	default:
+		repl.Reply(w, code, body)
+	}
+}
+
+// contentTypeOf returns ctype, or the Content-Type header set (eg. by
+// Response.Header), or application/octet-stream.
+func contentTypeOf(w http.ResponseWriter, ctype string) string {
+
+	if ctype != "" {
+		return ctype
+	}
+	if ctype = w.Header().Get("Content-Type"); ctype != "" {
+		return ctype
+	}
+	return octetStream
+}
+
+type sizeSeeker interface {
+	Size() int64
+	io.Seeker
+}
+
+// sizeOf returns the size of the unread part of a reader, or -1 if unknown.
+func sizeOf(r io.Reader) int64 {
+
+	switch v := r.(type) {
+	case interface{ Len() int }: // eg. *bytes.Buffer, *bytes.Reader, *strings.Reader
+		return int64(v.Len()) // the unread part, while Size() is the total one
+	case *os.File:
+		if fi, err := v.Stat(); err == nil && fi.Mode().IsRegular() {
+			if off, err := v.Seek(0, io.SeekCurrent); err == nil {
+				return fi.Size() - off
+			}
+		}
+	case sizeSeeker: // eg. *io.SectionReader
+		if off, err := v.Seek(0, io.SeekCurrent); err == nil {
+			return v.Size() - off
+		}
+	}
+	return -1
+}
+
+func replyStream(w http.ResponseWriter, req *http.Request, code int, ctype string, body io.Reader, size int64) {
+
+	if c, ok := body.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	if size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	} else {
+		h.Del("Content-Length")
+	}
+	w.WriteHeader(code)
+	if req.Method == "HEAD" || body == nil {
+		return
+	}
+
+	if size >= 0 {
+		n, err := io.Copy(w, io.LimitReader(body, size))
+		if err == nil && n < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			panic(http.ErrAbortHandler) // don't let the client take a truncated body as complete
+		}
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ---------------------------------------------------------------------------
@@ -6,8 +6,10 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"runtime/debug"
 	"syscall"
 
+	"github.com/qiniu/http/hfac"
 	"github.com/qiniu/http/httputil"
 )
 
@@ -35,6 +37,13 @@ type itfEnv interface {
 	CloseEnv()
 }
 
+// An Env may implement itfEnvContext to replace the Context passed to the
+// method, eg. to attach a user or a request id in OpenEnv. The returned
+// Context should be derived from req.Context().
+type itfEnvContext interface {
+	Context() context.Context
+}
+
 // ---------------------------------------------------------------------------
 
 // Replier represents a http replier.
@@ -42,6 +51,13 @@ type Replier struct {
 	Reply         func(w http.ResponseWriter, code int, data interface{})
 	ReplyWithCode func(w http.ResponseWriter, code int)
 	Error         func(w http.ResponseWriter, err error)
+
+	// For, if not nil, returns the Replier used for a request, eg. picked by
+	// the Accept header of the request. It is called only when a reply is
+	// encoded by the Replier, not for return values replied as is (eg. Blob,
+	// Stream, Event channels and lists). If it fails, the error is replied
+	// instead by the Error of the returned Replier (or this Replier if nil).
+	For func(req *http.Request) (*Replier, error)
 }
 
 var defaultRepl = &Replier{
@@ -50,6 +66,115 @@ var defaultRepl = &Replier{
 	Error:         httputil.Error,
 }
 
+// ProblemRepl is a Replier which replies errors as RFC 7807 problem details,
+// see httputil.Problem.
+var ProblemRepl = &Replier{
+	Reply:         httputil.Reply,
+	ReplyWithCode: httputil.ReplyWithCode,
+	Error:         httputil.Problem,
+}
+
+// NegotiateRepl is a Replier which replies in the media type picked by
+// httputil.Negotiate, or 406 if no media type acceptable to the request is
+// supported. Only replies encoded by a codec are negotiated; bodies replied
+// as is (eg. Blob, Event channels and lists) are not. Errors are replied by
+// httputil.Problem if the request accepts application/problem+json.
+var NegotiateRepl = &Replier{
+	Reply:         httputil.Reply,
+	ReplyWithCode: httputil.ReplyWithCode,
+	Error:         httputil.Error,
+	For:           negotiate,
+}
+
+// lazyRepl returns the Replier of req. If repl.For is set, the Replier is
+// picked only when a reply is encoded by it: return values replied as is (eg.
+// Blob, Stream, Event channels and lists) are not subject to repl.For. If it
+// fails (eg. 406), the error is replied instead of a successful reply; errors
+// are replied anyway.
+func lazyRepl(repl *Replier, req *http.Request) *Replier {
+
+	if repl.For == nil {
+		return repl
+	}
+	var picked *Replier
+	var pickErr error
+	pick := func() (*Replier, error) {
+		if picked == nil {
+			if picked, pickErr = repl.For(req); picked == nil {
+				picked = repl
+			}
+		}
+		return picked, pickErr
+	}
+	return &Replier{
+		Reply: func(w http.ResponseWriter, code int, data interface{}) {
+			repl1, err := pick()
+			if err != nil {
+				repl1.Error(w, err)
+				return
+			}
+			repl1.Reply(w, code, data)
+		},
+		ReplyWithCode: func(w http.ResponseWriter, code int) {
+			if !httputil.BodyAllowed(code) {
+				w.WriteHeader(code)
+				return
+			}
+			repl1, err := pick()
+			if err != nil {
+				repl1.Error(w, err)
+				return
+			}
+			repl1.ReplyWithCode(w, code)
+		},
+		Error: func(w http.ResponseWriter, err error) {
+			repl1, _ := pick()
+			repl1.Error(w, err)
+		},
+	}
+}
+
+func negotiate(req *http.Request) (*Replier, error) {
+
+	problem := httputil.AcceptsProblem(req)
+	mediaType, codec, err := httputil.Negotiate(req)
+	if err != nil {
+		if problem {
+			return ProblemRepl, err
+		}
+		return nil, err
+	}
+	if problem {
+		return &Replier{
+			Reply: func(w http.ResponseWriter, code int, data interface{}) {
+				httputil.ReplyWithCodec(w, code, mediaType, codec, data)
+			},
+			ReplyWithCode: func(w http.ResponseWriter, code int) {
+				if code >= 400 {
+					httputil.Problem(w, httputil.NewError(code, http.StatusText(code)))
+				} else {
+					httputil.ReplyWithCodeCodec(w, code, mediaType, codec)
+				}
+			},
+			Error: httputil.Problem,
+		}, nil
+	}
+	if codec == httputil.JSONCodec && mediaType == "application/json" {
+		return defaultRepl, nil
+	}
+	return &Replier{
+		Reply: func(w http.ResponseWriter, code int, data interface{}) {
+			httputil.ReplyWithCodec(w, code, mediaType, codec, data)
+		},
+		ReplyWithCode: func(w http.ResponseWriter, code int) {
+			httputil.ReplyWithCodeCodec(w, code, mediaType, codec)
+		},
+		Error: func(w http.ResponseWriter, err error) {
+			httputil.ErrorWithCodec(w, err, mediaType, codec)
+		},
+	}, nil
+}
+
 /* ---------------------------------------------------------------------------
 
 func (rcvr *XXXX) YYYY(req ZZZZ, env ENV) (err error)
@@ -68,6 +193,9 @@ func (rcvr *XXXX) YYYY() (ret RRRR, err error)
 在[]里面的参数是可选的(因为太多了，没有把Context放到上面的列表里面)
 func (rcvr *XXXX) YYYY(ctx Context[, req ZZZZ][, env ENV]) ([ret RRRR, ]err error)
 
+ctx 由 req.Context() 派生，客户端断开连接或服务器关闭时 ctx 会被取消。
+如果 ENV 实现了 Context() context.Context 方法，则在 OpenEnv 之后用它的返回值作为 ctx。
+
 // -------------------------------------------------------------------------*/
 
 var errMustPOST = httputil.NewError(http.StatusMethodNotAllowed, "Request method must be POST")
@@ -89,88 +217,111 @@ type handler struct {
 	hasCtx    int8  // 0: no Context 1: has Context
 	reqNotPtr int16
 	postOnly  int16
+	name      string
+	onPanic   func(req *http.Request, info *httputil.PanicInfo)
+	invoke    Invoker
+	code      int // status code of a successful reply
 }
 
-var zero reflect.Value
+func (h *handler) recover(w http.ResponseWriter, req *http.Request, repl *Replier) {
+
+	if v := recover(); v != nil {
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		info := &httputil.PanicInfo{
+			Method: h.name, Route: httputil.RouteOf(req), Value: v, Stack: debug.Stack(),
+		}
+		httputil.OnPanic(w, req, info, repl.Error, h.onPanic)
+	}
+}
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
+	w = httputil.WithLanguage(w, req.Header.Get("Accept-Language")) // see httputil.Localize
+	repl := lazyRepl(h.repl, req)
+	defer h.recover(w, req, repl)
+
 	if h.postOnly == 1 && req.Method != "POST" {
 		httputil.Error(w, errMustPOST)
 		return
 	}
 
-	repl := h.repl
-
-	var typeAddr, ctxAddr *reflect.Value
+	inv := &Invocation{Method: h.name, Ctx: req.Context(), Req: req}
 
-	args := make([]reflect.Value, 0, 4)
-
-	args = append(args, h.rcvr)
-
-	if h.hasCtx == 1 {
-		args = append(args, zero)
-		ctxAddr = &args[len(args)-1]
-	}
-
-	if h.reqType != nil {
-		args = append(args, zero)
-		typeAddr = &args[len(args)-1]
-	}
-
-	var err error
 	switch h.hasEnv {
-	case 0:
 	case 1:
-		args = append(args, reflect.ValueOf(Env{w, req}))
+		inv.Env = Env{w, req}
 	case 2:
 		env := reflect.New(h.envType)
 		env1 := env.Interface().(itfEnv)
-		err = env1.OpenEnv(h.rcvr.Interface(), &w, req)
+		err := env1.OpenEnv(h.rcvr.Interface(), &w, req)
 		if err != nil {
 			repl.Error(w, err)
 			return
 		}
 		defer env1.CloseEnv()
-		args = append(args, env)
+		if envCtx, ok := env1.(itfEnvContext); ok {
+			if ctx1 := envCtx.Context(); ctx1 != nil {
+				inv.Ctx = ctx1
+			}
+		}
+		inv.Env = env1
 	}
+	inv.W = w
 
 	if h.reqType != nil {
 		req1 := reflect.New(h.reqType)
-		err = h.parseReq(req1, req)
+		err := h.parseReq(req1, req)
 		if err != nil {
-			err2 := httputil.NewError(400, err.Error())
-			repl.Error(w, err2)
+			if _, ok := err.(*httputil.ErrorInfo); !ok {
+				err = httputil.NewError(400, err.Error())
+			}
+			repl.Error(w, err)
 			return
 		}
 		if h.reqNotPtr != 0 {
 			req1 = req1.Elem()
 		}
-		*typeAddr = req1
+		inv.Args = req1.Interface()
 	}
 
-	var out []reflect.Value
+	ret, err := h.invoke(inv)
+	if err != nil {
+		closeRet(ret)
+		repl.Error(w, err)
+		return
+	}
+
+	h.reply(w, req, repl, ret)
+}
+
+func (h *handler) call(inv *Invocation) (ret interface{}, err error) {
+
+	args := make([]reflect.Value, 0, 4)
+	args = append(args, h.rcvr)
 	if h.hasCtx == 1 {
-		*ctxAddr = reflect.ValueOf(context.Background())
+		args = append(args, reflect.ValueOf(inv.Ctx))
+	}
+	if h.reqType != nil {
+		args = append(args, reflect.ValueOf(inv.Args))
+	}
+	if h.hasEnv != 0 {
+		args = append(args, reflect.ValueOf(inv.Env))
 	}
 
-	out = h.method.Call(args)
+	out := h.method.Call(args)
 	if h.hasRet < 0 {
 		return
 	}
 
-	err1 := out[h.hasRet]
-	if !err1.IsNil() {
-		e := err1.Interface().(error)
-		repl.Error(w, e)
-		return
+	if err1 := out[h.hasRet]; !err1.IsNil() {
+		err = err1.Interface().(error)
 	}
-
 	if h.hasRet != 0 {
-		repl.Reply(w, 200, out[0].Interface())
-	} else {
-		repl.ReplyWithCode(w, 200)
+		ret = out[0].Interface()
 	}
+	return
 }
 
 // ---------------------------------------------------------------------------
@@ -191,10 +342,52 @@ type HandlerCreator struct {
 	Repl         *Replier
 	ReqMayNotPtr bool
 	PostOnly     bool
+
+	// OnPanic, if not nil, is called after a panic of the method is recovered
+	// and logged, eg. to report it to an alerting system. A 500 error is
+	// replied by calling Repl.Error, or the response is aborted if it has
+	// started, see httputil.OnPanic.
+	OnPanic func(req *http.Request, info *httputil.PanicInfo)
+
+	// Interceptors are run in order around every method call. MethodInterceptors
+	// are keyed by method name (eg. "PostFoo_Bar") and run inside Interceptors.
+	Interceptors       []Interceptor
+	MethodInterceptors map[string][]Interceptor
+
+	// StatusCodes are the status codes of successful replies, keyed by method
+	// name (eg. "PostJobs_Cancel") or by prefix of method names (eg. "Post").
+	// A method name takes precedence over a prefix; other methods reply 200.
+	// A return value may override it, see Response.
+	StatusCodes map[string]int
 }
 
-// New creates a http handler.
-func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+// StatusCode returns the status code of successful replies of the method named
+// name, see StatusCodes.
+func (p HandlerCreator) StatusCode(name string) int {
+
+	if code, ok := p.StatusCodes[name]; ok {
+		return code
+	}
+	if prefix, ok := hfac.PrefixOf(name); ok {
+		if code, ok := p.StatusCodes[prefix]; ok {
+			return code
+		}
+	}
+	return 200
+}
+
+// MethodSpec describes the signature of a handler method.
+type MethodSpec struct {
+	ReqType   reflect.Type // type of req (pointer stripped), nil if the method has no req argument
+	EnvType   reflect.Type // Env or *ENV, nil if the method has no env argument
+	RetType   reflect.Type // type of ret, nil if the method returns no ret
+	HasCtx    bool         // the method has a ctx argument
+	HasErr    bool         // the method returns an error; otherwise it replies by itself
+	ReqNotPtr bool         // req is not passed by pointer
+}
+
+// Spec checks the signature of a handler method.
+func (p HandlerCreator) Spec(method reflect.Method) (spec *MethodSpec, err error) {
 
 	mtype := method.Type
 
@@ -213,22 +406,19 @@ func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Han
 	// 在[]里面的参数是可选的(因为太多了，没有把Context放到上面的列表里面)
 	//  (rcvr *XXXX) YYYY(ctx Context[, req ZZZZ][, env ENV]) ([ret RRRR, ]err error)
 	//
-	var envType reflect.Type
-	var hasEnv = 0
+	spec = new(MethodSpec)
 	var hasRet = mtype.NumOut() - 1
-	var hasCtx = 0
 	var narg = mtype.NumIn()
 	if narg > 1 {
 		lastArg := mtype.In(narg - 1)
 		if lastArg.Kind() == reflect.Struct {
 			if lastArg == typeOfEnv {
-				hasEnv = 1
+				spec.EnvType = lastArg
 				narg--
 			}
 		} else if lastArg.Kind() == reflect.Ptr {
 			if lastArg.Implements(typeOfIEnv) {
-				envType = lastArg.Elem()
-				hasEnv = 2
+				spec.EnvType = lastArg
 				narg--
 			}
 		}
@@ -239,7 +429,7 @@ func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Han
 		secArg := mtype.In(1)
 
 		if secArg.Implements(typeOfContext) {
-			hasCtx = 1
+			spec.HasCtx = true
 			narg--
 			nargBase++
 		}
@@ -252,18 +442,17 @@ func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Han
 		return nil, syscall.EINVAL
 	}
 
-	var reqNotPtr int16
-	var reqType reflect.Type
 	if narg == 2 {
-		reqType = mtype.In(nargBase + 1)
+		reqType := mtype.In(nargBase + 1)
 		if reqType.Kind() == reflect.Ptr {
 			reqType = reqType.Elem()
 		} else if p.ReqMayNotPtr {
-			reqNotPtr = 1
+			spec.ReqNotPtr = true
 		} else {
 			log.Println("method", method.Name, "arg type not a pointer:", reqType)
 			return nil, syscall.EINVAL
 		}
+		spec.ReqType = reqType
 	}
 
 	if hasRet >= 0 {
@@ -271,11 +460,49 @@ func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Han
 			log.Println("method", method.Name, "returns", errType.String(), "not error")
 			return nil, syscall.EINVAL
 		}
+		spec.HasErr = true
+		if hasRet > 0 {
+			spec.RetType = mtype.Out(0)
+		}
+	}
+	return
+}
+
+// New creates a http handler.
+func (p HandlerCreator) New(rcvr reflect.Value, method reflect.Method) (http.Handler, error) {
+
+	spec, err := p.Spec(method)
+	if err != nil {
+		return nil, err
 	}
 
+	var envType reflect.Type
+	var hasEnv, hasCtx, reqNotPtr = 0, 0, 0
+	var hasRet = method.Type.NumOut() - 1
+	if spec.EnvType == typeOfEnv {
+		hasEnv = 1
+	} else if spec.EnvType != nil {
+		envType = spec.EnvType.Elem()
+		hasEnv = 2
+	}
+	if spec.HasCtx {
+		hasCtx = 1
+	}
+	if spec.ReqNotPtr {
+		reqNotPtr = 1
+	}
+	reqType := spec.ReqType
+
 	h := &handler{
 		rcvr, method.Func, reqType, envType,
-		p.ParseReq, defaultRepl, int16(hasEnv), int8(hasRet), int8(hasCtx), reqNotPtr, 0}
+		p.ParseReq, defaultRepl, int16(hasEnv), int8(hasRet), int8(hasCtx), int16(reqNotPtr), 0,
+		method.Name, p.OnPanic, nil, p.StatusCode(method.Name)}
+
+	interceptors := p.Interceptors
+	if mis := p.MethodInterceptors[method.Name]; len(mis) > 0 {
+		interceptors = append(interceptors[:len(interceptors):len(interceptors)], mis...)
+	}
+	h.invoke = Chain(interceptors, h.call)
 
 	if h.parseReq == nil && p.SelParseReq != nil {
 		if reqType != nil {
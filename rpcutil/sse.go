@@ -0,0 +1,177 @@
+package rpcutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ---------------------------------------------------------------------------
+
+方法返回 <-chan Event(或 *EventStream)时，handler 以 Server-Sent Events(text/event-stream)
+回复，每个事件写出后立即 flush，channel 关闭时响应结束：
+
+	func (p *Service) GetJobs_Events(env *restrpc.Env) (ret <-chan rpcutil.Event, err error) {
+		job, err := p.jobs.Get(env.Args[0])
+		if err != nil {
+			return
+		}
+		ch := make(chan rpcutil.Event)
+		go func() {
+			defer close(ch)
+			from := rpcutil.LastEventID(env.Req) // 断线重连时从这里继续
+			for progress := range job.Watch(env.Ctx, from) {
+				select {
+				case ch <- rpcutil.Event{ID: progress.Seq, Event: "progress", Data: progress}:
+				case <-env.Ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+
+- Data 为 string 或 []byte 时原样发送(多行拆成多个 data 字段)，其他值编码为 JSON。
+- 没有事件时每隔 Heartbeat(缺省为 DefaultHeartbeat)发送一个注释行，以免连接被代理断开。
+- 请求的 Context 结束(客户端断开或服务器关闭)时 handler 返回，之后 channel 中的事件被丢弃
+  直到 channel 关闭。产生事件的 goroutine 应该同时等待 ctx.Done()，见上面的例子。
+
+注意 http.Server 的 WriteTimeout 同样作用于事件流。
+
+// -------------------------------------------------------------------------*/
+
+// Event is a Server-Sent Event.
+type Event struct {
+	ID    string        // sent as the id field if not empty, see LastEventID
+	Event string        // event type; "message" if empty
+	Data  interface{}   // a string or []byte is sent as is; other values are encoded in JSON
+	Retry time.Duration // reconnection time of the client if not 0
+}
+
+// EventStream is a return value replied as Server-Sent Events. A method may
+// also return <-chan Event, with the default heartbeat.
+type EventStream struct {
+	Events    <-chan Event
+	Heartbeat time.Duration // DefaultHeartbeat if 0; no heartbeat if < 0
+}
+
+// DefaultHeartbeat is the default interval of heartbeats of an EventStream.
+var DefaultHeartbeat = 15 * time.Second
+
+// LastEventID returns the ID of the last event received by a reconnecting
+// client (the Last-Event-ID header), or "" if none.
+func LastEventID(req *http.Request) string {
+
+	return req.Header.Get("Last-Event-ID")
+}
+
+var eventFieldReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+func (e *Event) encode() ([]byte, error) {
+
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: " + eventFieldReplacer.Replace(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + eventFieldReplacer.Replace(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+
+	var data string
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		msg, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = string(msg)
+	}
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+var heartbeat = []byte(":\n\n")
+
+func serveEvents(w http.ResponseWriter, req *http.Request, s *EventStream) {
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // don't let nginx buffer events
+	h.Del("Content-Length")
+	w.WriteHeader(200)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	var tick <-chan time.Time
+	interval := s.Heartbeat
+	if interval == 0 {
+		interval = DefaultHeartbeat
+	}
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	ctx := req.Context()
+	for {
+		select {
+		case e, ok := <-s.Events:
+			if !ok {
+				return
+			}
+			msg, err := e.encode()
+			if err != nil {
+				log.Println("rpcutil: encode event failed:", err)
+				continue
+			}
+			if _, err = w.Write(msg); err != nil {
+				go drainEvents(s.Events)
+				return
+			}
+			flush()
+		case <-tick:
+			if _, err := w.Write(heartbeat); err != nil {
+				go drainEvents(s.Events)
+				return
+			}
+			flush()
+		case <-ctx.Done():
+			go drainEvents(s.Events)
+			return
+		}
+	}
+}
+
+// drainEvents discards events until the channel is closed, so the producer
+// isn't blocked forever if it doesn't watch the request Context.
+func drainEvents(events <-chan Event) {
+
+	for range events {
+	}
+}
+
+// ---------------------------------------------------------------------------
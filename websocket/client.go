@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+)
+
+// ---------------------------------------------------------------------------
+
+// ErrBadHandshake is returned by Dial if the server accepts the handshake
+// with an invalid response.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Dial connects to a WebSocket server at rawurl (ws://, wss://, http:// or
+// https://), with header added to the handshake request (eg. Authorization or
+// Sec-WebSocket-Protocol). If the server rejects the handshake, the error
+// replied is returned as an *httputil.ErrorInfo (see httputil.ResponseError).
+func Dial(ctx context.Context, rawurl string, header http.Header) (*Conn, error) {
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme, secure = "https", true
+	default:
+		return nil, errors.New("websocket: unsupported scheme: " + u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+
+	var d net.Dialer
+	netConn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		tlsConn := tls.Client(netConn, &tls.Config{ServerName: u.Hostname()})
+		if err = tlsConn.Handshake(); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(b[:])
+
+	req := &http.Request{Method: "GET", URL: u, Host: u.Host, Header: make(http.Header)}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err = req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		err = httputil.ResponseError(resp)
+		netConn.Close()
+		return nil, err
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		netConn.Close()
+		return nil, ErrBadHandshake
+	}
+	netConn.SetDeadline(time.Time{})
+	return newConn(netConn, br, false, resp.Header.Get("Sec-Websocket-Protocol")), nil
+}
+
+// ---------------------------------------------------------------------------
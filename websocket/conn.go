@@ -0,0 +1,499 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/* ---------------------------------------------------------------------------
+
+websocket 实现 RFC 6455 的 WebSocket 协议(不支持扩展，如 permessage-deflate)，只依赖标准库。
+
+服务端用 Upgrader.Upgrade 把一个 http 请求升级为 *Conn，客户端用 Dial 建立连接：
+
+	conn, err := websocket.Upgrade(w, req, nil)
+	if err != nil {
+		return // Upgrade 已经回复了错误
+	}
+	defer conn.Close()
+	conn.KeepAlive(ctx, 30*time.Second)
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(typ, msg)
+	}
+
+ReadMessage 自动处理控制帧：回复 ping，收到 close 时回复 close 并返回 *CloseError。所以即使
+不关心对方的消息，也需要一直调用 ReadMessage。WriteMessage 等写方法可以在多个 goroutine 中
+并发调用，ReadMessage 只能在一个 goroutine 中调用。
+
+hfac 的 Ws 前缀基于这个包，见 hfac.NewWsHandler。
+
+// -------------------------------------------------------------------------*/
+
+// Message types, see RFC 6455, section 11.8.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes, see RFC 6455, section 7.4.1.
+const (
+	CloseNormalClosure       = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatusReceived    = 1005
+	CloseAbnormalClosure     = 1006
+	CloseInvalidPayload      = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseInternalServerError = 1011
+)
+
+// CloseError is returned by ReadMessage if the peer closes the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+
+	return "websocket: closed: " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+var (
+	ErrCloseSent    = errors.New("websocket: close sent")
+	ErrReadLimit    = errors.New("websocket: message too large")
+	errProtocol     = errors.New("websocket: protocol error")
+	errInvalidUTF8  = errors.New("websocket: invalid UTF-8 in text message")
+	errUnknownFrame = errors.New("websocket: unknown frame")
+)
+
+// DefaultMaxMessageSize is the default limit of the size of a message read.
+const DefaultMaxMessageSize = 1 << 20
+
+const maxControlPayload = 125
+
+// Conn is a WebSocket connection.
+type Conn struct {
+	// MaxMessageSize limits the size of a message read; DefaultMaxMessageSize if 0.
+	MaxMessageSize int64
+
+	conn        net.Conn
+	br          *bufio.Reader
+	server      bool // frames read must be masked, frames written are not
+	subprotocol string
+	readTimeout time.Duration
+
+	wmutex    sync.Mutex
+	closeSent bool
+
+	dmutex        sync.Mutex // serializes changes of the write deadline
+	writeDeadline time.Time  // set by SetWriteDeadline
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, server bool, subprotocol string) *Conn {
+
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, server: server, subprotocol: subprotocol, done: make(chan struct{})}
+}
+
+// Subprotocol returns the subprotocol negotiated, or "" if none.
+func (c *Conn) Subprotocol() string {
+
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+
+	return c.conn
+}
+
+// Done returns a channel closed after the connection is closed, or reading
+// from it fails.
+func (c *Conn) Done() <-chan struct{} {
+
+	return c.done
+}
+
+func (c *Conn) setDone() {
+
+	c.doneOnce.Do(func() { close(c.done) })
+}
+
+// ---------------------------------------------------------------------------
+
+// ReadMessage reads the next text or binary message. It replies pings, and
+// returns a *CloseError after replying the close frame of the peer.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.readFailed(err)
+		}
+		switch op {
+		case PingMessage:
+			if err = c.writeFrame(PongMessage, payload); err != nil && err != ErrCloseSent {
+				return 0, nil, c.readFailed(err)
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			if len(payload) == 1 {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol)
+			}
+			e := &CloseError{Code: CloseNoStatusReceived}
+			if len(payload) >= 2 {
+				e.Code = int(binary.BigEndian.Uint16(payload))
+				e.Text = string(payload[2:])
+			}
+			c.WriteClose(e.Code, "") // an empty close frame for 1005
+			c.setDone()
+			return 0, nil, e
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol) // a new message inside a fragmented one
+			}
+			messageType, p = op, payload
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol)
+			}
+			if int64(len(p)+len(payload)) > c.maxMessageSize() {
+				return 0, nil, c.fail(CloseMessageTooBig, ErrReadLimit)
+			}
+			p = append(p, payload...)
+		default:
+			return 0, nil, c.fail(CloseProtocolError, errUnknownFrame)
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(p) {
+				return 0, nil, c.fail(CloseInvalidPayload, errInvalidUTF8)
+			}
+			return messageType, p, nil
+		}
+	}
+}
+
+// ReadJSON reads the next message and decodes it in JSON into v.
+func (c *Conn) ReadJSON(v interface{}) error {
+
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+func (c *Conn) maxMessageSize() int64 {
+
+	if c.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+
+	var h [8]byte
+	if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	op = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	if h[0]&0x70 != 0 || masked != c.server { // no extensions; only frames from clients are masked
+		return false, 0, nil, errProtocol
+	}
+
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, h[:8]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(h[:8]))
+	}
+	if op >= CloseMessage && (!fin || n > maxControlPayload) {
+		return false, 0, nil, errProtocol
+	}
+	if n < 0 || n > c.maxMessageSize() {
+		return false, 0, nil, ErrReadLimit
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return
+}
+
+// readFailed closes the connection after a read error.
+func (c *Conn) readFailed(err error) error {
+
+	switch err {
+	case errProtocol:
+		return c.fail(CloseProtocolError, err)
+	case ErrReadLimit:
+		return c.fail(CloseMessageTooBig, err)
+	}
+	c.setDone()
+	return err
+}
+
+// fail sends a close frame of code, and returns err.
+func (c *Conn) fail(code int, err error) error {
+
+	c.WriteClose(code, "")
+	c.conn.Close()
+	c.setDone()
+	return err
+}
+
+func maskBytes(key [4]byte, b []byte) {
+
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// WriteMessage writes a text or binary message.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errUnknownFrame
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteJSON writes v encoded in JSON as a text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(TextMessage, msg)
+}
+
+// Ping sends a ping frame. The peer replies a pong, see KeepAlive.
+func (c *Conn) Ping(data []byte) error {
+
+	if len(data) > maxControlPayload {
+		return errProtocol
+	}
+	return c.writeFrame(PingMessage, data)
+}
+
+// WriteClose sends a close frame, starting the closing handshake. No message
+// can be written after it. The close frame has no payload if code is
+// CloseNoStatusReceived or CloseAbnormalClosure, which must not be sent (see
+// RFC 6455, section 7.4.1).
+func (c *Conn) WriteClose(code int, text string) error {
+
+	return c.writeClose(code, text, time.Time{})
+}
+
+func (c *Conn) writeClose(code int, text string, deadline time.Time) error {
+
+	if code == CloseNoStatusReceived || code == CloseAbnormalClosure {
+		return c.writeCloseFrame(nil, deadline)
+	}
+	text = truncateReason(text)
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+	return c.writeCloseFrame(payload, deadline)
+}
+
+// MaxCloseReason is the limit of the size of the reason of a close frame; a
+// longer reason is cut on a UTF-8 boundary.
+const MaxCloseReason = maxControlPayload - 2
+
+func truncateReason(text string) string {
+
+	if len(text) <= MaxCloseReason {
+		return text
+	}
+	n := MaxCloseReason
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+func (c *Conn) writeCloseFrame(payload []byte, deadline time.Time) error {
+
+	err := c.writeFrameDeadline(CloseMessage, payload, deadline)
+	c.wmutex.Lock()
+	c.closeSent = true
+	c.wmutex.Unlock()
+	return err
+}
+
+// SetWriteDeadline sets the deadline of writes, see net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+
+	c.dmutex.Lock()
+	defer c.dmutex.Unlock()
+	c.writeDeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
+
+// setFrameDeadline sets the deadline of writing a frame, or restores the one
+// set by SetWriteDeadline if t is zero.
+func (c *Conn) setFrameDeadline(t time.Time) {
+
+	c.dmutex.Lock()
+	defer c.dmutex.Unlock()
+	if t.IsZero() {
+		t = c.writeDeadline
+	}
+	c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) writeFrame(op int, data []byte) error {
+
+	return c.writeFrameDeadline(op, data, time.Time{})
+}
+
+// writeFrameDeadline writes a frame. If deadline isn't zero, it's the
+// deadline of writing this frame only.
+func (c *Conn) writeFrameDeadline(op int, data []byte, deadline time.Time) error {
+
+	buf := make([]byte, 0, 14+len(data))
+	buf = append(buf, 0x80|byte(op)) // FIN, no fragmentation
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch n := len(data); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, maskBit|127)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		buf = append(buf, b[:]...)
+	}
+	if c.server {
+		buf = append(buf, data...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		pos := len(buf)
+		buf = append(buf, data...)
+		maskBytes(key, buf[pos:])
+	}
+
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if !deadline.IsZero() {
+		c.setFrameDeadline(deadline)
+		defer c.setFrameDeadline(time.Time{})
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// ---------------------------------------------------------------------------
+
+// KeepAlive pings the peer every interval until ctx is done or the
+// connection is closed, and fails ReadMessage if nothing (including pongs)
+// is read from the peer in 2 intervals.
+func (c *Conn) KeepAlive(ctx context.Context, interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+	c.readTimeout = 2 * interval
+	c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.writeFrameDeadline(PingMessage, nil, time.Now().Add(interval)); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close sends a normal close frame if no close frame is sent, and closes the
+// connection.
+func (c *Conn) Close() error {
+
+	return c.CloseWithCode(CloseNormalClosure, "")
+}
+
+// CloseWithCode sends a close frame of code if no close frame is sent, and
+// closes the connection.
+func (c *Conn) CloseWithCode(code int, text string) error {
+
+	c.writeClose(code, text, time.Now().Add(time.Second))
+	c.setDone()
+	return c.conn.Close()
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,167 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+)
+
+// ---------------------------------------------------------------------------
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key string) string {
+
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+var (
+	errNotWebSocket  = httputil.NewError(http.StatusBadRequest, "not a websocket handshake")
+	errBadVersion    = httputil.NewError(http.StatusUpgradeRequired, "unsupported websocket version")
+	errBadOrigin     = httputil.NewError(http.StatusForbidden, "origin not allowed")
+	errNotHijackable = httputil.NewError(http.StatusInternalServerError, "response can't be hijacked")
+)
+
+// Upgrader upgrades http requests to WebSocket connections.
+type Upgrader struct {
+	// Subprotocols are the subprotocols supported, in order of preference.
+	Subprotocols []string
+
+	// CheckOrigin reports whether the Origin of a request is allowed. If nil,
+	// a request is allowed if it has no Origin or its Origin is of the same
+	// host as the request.
+	CheckOrigin func(req *http.Request) bool
+
+	// MaxMessageSize is set to Conn.MaxMessageSize.
+	MaxMessageSize int64
+}
+
+// DefaultUpgrader is the Upgrader used by Upgrade.
+var DefaultUpgrader = &Upgrader{}
+
+// Upgrade upgrades req to a WebSocket connection by DefaultUpgrader.
+func Upgrade(w http.ResponseWriter, req *http.Request, header http.Header) (*Conn, error) {
+
+	return DefaultUpgrader.Upgrade(w, req, header)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+
+	for _, v := range h[name] {
+		for _, item := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameOrigin(req *http.Request) bool {
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// hijackerOf finds the http.Hijacker of w, unwrapping the response writers
+// of middlewares (see httputil.Compression).
+func hijackerOf(w http.ResponseWriter) (http.Hijacker, bool) {
+
+	for {
+		if h, ok := w.(http.Hijacker); ok {
+			return h, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Upgrade upgrades req to a WebSocket connection, with header added to the
+// handshake response (eg. Set-Cookie). If it fails, the error is replied by
+// httputil.Error.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, req *http.Request, header http.Header) (*Conn, error) {
+
+	if req.Method != "GET" || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") {
+		httputil.Error(w, errNotWebSocket)
+		return nil, errNotWebSocket
+	}
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		httputil.Error(w, errBadVersion)
+		return nil, errBadVersion
+	}
+	key := req.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		httputil.Error(w, errNotWebSocket)
+		return nil, errNotWebSocket
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		httputil.Error(w, errBadOrigin)
+		return nil, errBadOrigin
+	}
+
+	var subprotocol string
+	for _, p := range u.Subprotocols {
+		if headerContains(req.Header, "Sec-Websocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+
+	hj, ok := hijackerOf(w)
+	if !ok {
+		httputil.Error(w, errNotHijackable)
+		return nil, errNotHijackable
+	}
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		httputil.Error(w, err)
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+
+	netConn.SetDeadline(time.Time{})
+	netConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err = netConn.Write([]byte(b.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetWriteDeadline(time.Time{})
+
+	c := newConn(netConn, brw.Reader, true, subprotocol) // brw.Reader may have read frames already
+	c.MaxMessageSize = u.MaxMessageSize
+	return c, nil
+}
+
+// ---------------------------------------------------------------------------
@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/http/httputil"
+)
+
+// ---------------------------------------------------------------------------
+
+func TestAcceptKey(t *testing.T) {
+
+	// the example of RFC 6455, section 1.3
+	if key := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("acceptKey:", key)
+	}
+}
+
+func echoServer(t *testing.T, u *Upgrader) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := u.Upgrade(w, req, http.Header{"X-Echo": {"1"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "bye" {
+				conn.CloseWithCode(4000, "bye")
+				return
+			}
+			conn.WriteMessage(typ, msg)
+		}
+	}))
+}
+
+func dial(t *testing.T, svr *httptest.Server, header http.Header) *Conn {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, "ws"+strings.TrimPrefix(svr.URL, "http"), header)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	conn.NetConn().SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestEcho(t *testing.T) {
+
+	svr := echoServer(t, &Upgrader{Subprotocols: []string{"v2", "v1"}})
+	defer svr.Close()
+
+	conn := dial(t, svr, http.Header{"Sec-Websocket-Protocol": {"v1, v2"}})
+	defer conn.Close()
+	if conn.Subprotocol() != "v2" {
+		t.Fatal("unexpected subprotocol:", conn.Subprotocol())
+	}
+
+	large := bytes.Repeat([]byte("x"), 70000) // 64-bit payload length
+	cases := []struct {
+		typ int
+		msg []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, []byte{0, 1, 2, 0xff}},
+		{TextMessage, bytes.Repeat([]byte("y"), 300)}, // 16-bit payload length
+		{BinaryMessage, large},
+		{TextMessage, []byte{}},
+	}
+	for _, c := range cases {
+		if err := conn.WriteMessage(c.typ, c.msg); err != nil {
+			t.Fatal("WriteMessage failed:", err)
+		}
+		if err := conn.Ping([]byte("ping")); err != nil { // pongs are skipped by ReadMessage
+			t.Fatal("Ping failed:", err)
+		}
+		typ, msg, err := conn.ReadMessage()
+		if err != nil || typ != c.typ || !bytes.Equal(msg, c.msg) {
+			t.Fatal("ReadMessage:", typ, len(msg), err)
+		}
+	}
+
+	var v struct{ A int }
+	conn.WriteJSON(map[string]int{"A": 3})
+	if err := conn.ReadJSON(&v); err != nil || v.A != 3 {
+		t.Fatal("ReadJSON:", v, err)
+	}
+
+	conn.WriteMessage(TextMessage, []byte("bye"))
+	_, _, err := conn.ReadMessage()
+	if e, ok := err.(*CloseError); !ok || e.Code != 4000 || e.Text != "bye" {
+		t.Fatal("unexpected close:", err)
+	}
+	if err = conn.WriteMessage(TextMessage, []byte("x")); err != ErrCloseSent {
+		t.Fatal("WriteMessage after close:", err)
+	}
+	select {
+	case <-conn.Done():
+	default:
+		t.Fatal("conn isn't done")
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+
+	svr := echoServer(t, &Upgrader{MaxMessageSize: 10})
+	defer svr.Close()
+
+	conn := dial(t, svr, nil)
+	defer conn.Close()
+	conn.WriteMessage(TextMessage, []byte("12345678901"))
+	_, _, err := conn.ReadMessage()
+	if e, ok := err.(*CloseError); !ok || e.Code != CloseMessageTooBig {
+		t.Fatal("unexpected close:", err)
+	}
+}
+
+func TestCloseFrames(t *testing.T) {
+
+	svr := echoServer(t, DefaultUpgrader)
+	defer svr.Close()
+
+	cases := []struct {
+		payload []byte
+		reply   []byte
+	}{
+		{nil, []byte{}},             // no status: 1005 isn't sent back
+		{[]byte{3}, []byte{3, 234}}, // malformed: 1002
+		{[]byte{3, 232, 'o', 'k'}, []byte{3, 232}},
+	}
+	for i, c := range cases {
+		conn := dial(t, svr, nil)
+		if err := conn.writeFrame(CloseMessage, c.payload); err != nil {
+			t.Fatal(i, "writeFrame failed:", err)
+		}
+		_, op, payload, err := conn.readFrame()
+		if err != nil || op != CloseMessage || !bytes.Equal(payload, c.reply) {
+			t.Fatal(i, "unexpected reply:", op, payload, err)
+		}
+		conn.NetConn().Close()
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+
+	svr := echoServer(t, DefaultUpgrader)
+	defer svr.Close()
+
+	conn := dial(t, svr, nil)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn.KeepAlive(ctx, 20*time.Millisecond)
+
+	// the server replies pongs, so reading doesn't time out
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.WriteMessage(TextMessage, []byte("late"))
+	}()
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "late" {
+		t.Fatal("ReadMessage:", string(msg), err)
+	}
+}
+
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func TestFrameDeadline(t *testing.T) {
+
+	nc := new(deadlineConn)
+	conn := newConn(nc, nil, true, "")
+	t1 := time.Now().Add(time.Hour)
+	conn.SetWriteDeadline(t1)
+
+	// a ping of KeepAlive doesn't clear the deadline set before
+	if err := conn.writeFrameDeadline(PingMessage, nil, time.Now().Add(time.Second)); err != nil || !nc.deadline.Equal(t1) {
+		t.Fatal("writeFrameDeadline:", nc.deadline, err)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+
+	svr := echoServer(t, DefaultUpgrader)
+	defer svr.Close()
+
+	cases := []struct {
+		header http.Header
+		code   int
+	}{
+		{http.Header{}, 400},
+		{http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}, "Sec-Websocket-Version": {"8"}}, 426},
+		{http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}, "Sec-Websocket-Version": {"13"}}, 400},
+		{http.Header{"Upgrade": {"websocket"}, "Connection": {"keep-alive, Upgrade"}, "Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ=="}, "Origin": {"http://example.com"}}, 403},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", svr.URL, nil)
+		req.Header = c.header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(i, "request failed:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Fatal(i, "unexpected code:", resp.StatusCode, "expected:", c.code)
+		}
+	}
+
+	ctx := context.Background()
+	_, err := Dial(ctx, "ws"+strings.TrimPrefix(svr.URL, "http"), http.Header{"Origin": {"http://example.com"}})
+	if code, _, _ := httputil.GetErrorInfo(err); code != 403 {
+		t.Fatal("Dial:", err)
+	}
+}
+
+// ---------------------------------------------------------------------------